@@ -0,0 +1,179 @@
+package accesslog
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/requestinfo"
+)
+
+// Config configures an access log
+type Config struct {
+	// Output is the sink: stdout, stderr, syslog, syslog://host:port, udp://host:port or a file path
+	Output string
+	// Format is one of json (default), common or combined
+	Format string
+	// Fields selects the fields of json entries, all fields if empty
+	Fields []string
+	// MaxSize is the size in megabytes after which a log file is rotated
+	MaxSize int
+	// MaxBackups is the number of rotated log files to keep
+	MaxBackups int
+	// MaxAge is the number of days to keep rotated log files
+	MaxAge int
+}
+
+// AllFields are the fields available in json entries
+var AllFields = []string{
+	"time", "client", "host", "method", "uri", "proto", "status", "bytes",
+	"rule", "loadbalancer", "upstream", "duration", "upstream_duration",
+	"tls_version", "referer", "user_agent",
+}
+
+// Logger writes access log entries to a sink
+type Logger struct {
+	mutex  sync.Mutex
+	format string
+	fields []string
+	sink   io.WriteCloser
+}
+
+// New returns a new access logger
+func New(cfg *Config) (*Logger, error) {
+	switch cfg.Format {
+	case "":
+		cfg.Format = "json"
+	case "json", "common", "combined":
+	default:
+		return nil, fmt.Errorf("unknown access log format '%v'", cfg.Format)
+	}
+	fields := cfg.Fields
+	if len(fields) == 0 {
+		fields = AllFields
+	}
+	for _, field := range fields {
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown access log field '%v'", field)
+		}
+	}
+	sink, err := openSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{format: cfg.Format, fields: fields, sink: sink}, nil
+}
+
+// Log writes an entry for a finished request
+func (logger *Logger) Log(req *http.Request, info *requestinfo.Info) error {
+	var line []byte
+	switch logger.format {
+	case "common":
+		line = []byte(commonLine(req, info) + "\n")
+	case "combined":
+		line = []byte(fmt.Sprintf("%v %q %q\n", commonLine(req, info), req.Referer(), req.UserAgent()))
+	default:
+		entry := make(map[string]interface{}, len(logger.fields))
+		for _, field := range logger.fields {
+			entry[field] = fieldValue(field, req, info)
+		}
+		bs, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		line = append(bs, '\n')
+	}
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	_, err := logger.sink.Write(line)
+	return err
+}
+
+// Close closes the sink
+func (logger *Logger) Close() error {
+	return logger.sink.Close()
+}
+
+func isField(name string) bool {
+	for _, field := range AllFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldValue(field string, req *http.Request, info *requestinfo.Info) interface{} {
+	switch field {
+	case "time":
+		return info.Start.Format(time.RFC3339Nano)
+	case "client":
+		return clientHost(req)
+	case "host":
+		return req.Host
+	case "method":
+		return req.Method
+	case "uri":
+		return req.RequestURI
+	case "proto":
+		return req.Proto
+	case "status":
+		return info.Status
+	case "bytes":
+		return info.Bytes
+	case "rule":
+		return info.RuleID
+	case "loadbalancer":
+		return info.Loadbalancer
+	case "upstream":
+		return info.Upstream
+	case "duration":
+		return time.Since(info.Start).Seconds()
+	case "upstream_duration":
+		return info.UpstreamDuration.Seconds()
+	case "tls_version":
+		if req.TLS == nil {
+			return ""
+		}
+		return tls.VersionName(req.TLS.Version)
+	case "referer":
+		return req.Referer()
+	case "user_agent":
+		return req.UserAgent()
+	}
+	return nil
+}
+
+// commonLine formats an entry in the Common Log Format
+func commonLine(req *http.Request, info *requestinfo.Info) string {
+	user := "-"
+	if name, _, ok := req.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	bytes := "-"
+	if info.Bytes > 0 {
+		bytes = strconv.FormatInt(info.Bytes, 10)
+	}
+	return fmt.Sprintf("%v - %v [%v] \"%v %v %v\" %v %v",
+		clientHost(req),
+		user,
+		info.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method, req.RequestURI, req.Proto,
+		info.Status,
+		bytes,
+	)
+}
+
+func clientHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,48 @@
+package accesslog
+
+import (
+	"errors"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// nopCloser keeps the standard streams open when a logger is closed
+type nopCloser struct {
+	*os.File
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+var errNoOutput = errors.New("no access log output specified")
+
+// openSink opens the output of cfg. Files are rotated when they exceed cfg.MaxSize megabytes (default 100).
+func openSink(cfg *Config) (io.WriteCloser, error) {
+	output := cfg.Output
+	switch {
+	case output == "":
+		return nil, errNoOutput
+	case output == "stdout":
+		return nopCloser{os.Stdout}, nil
+	case output == "stderr":
+		return nopCloser{os.Stderr}, nil
+	case output == "syslog":
+		return syslog.New(syslog.LOG_INFO|syslog.LOG_LOCAL0, "eve")
+	case strings.HasPrefix(output, "syslog://"):
+		return syslog.Dial("udp", strings.TrimPrefix(output, "syslog://"), syslog.LOG_INFO|syslog.LOG_LOCAL0, "eve")
+	case strings.HasPrefix(output, "udp://"):
+		return net.Dial("udp", strings.TrimPrefix(output, "udp://"))
+	}
+	return &lumberjack.Logger{
+		Filename:   output,
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+	}, nil
+}
@@ -0,0 +1,294 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/trusch/eve/config"
+	"github.com/trusch/eve/handler"
+	lbRule "github.com/trusch/eve/loadbalancer/rule"
+	"github.com/trusch/eve/logging"
+	mwRule "github.com/trusch/eve/middleware/rule"
+	"github.com/trusch/eve/server"
+)
+
+// API is the admin HTTP API. It serves eve's live state as JSON and,
+// if a token is configured, accepts config changes which are fed into
+// the application like any other config.Stream
+type API struct {
+	addr    string
+	token   string
+	handler *handler.Handler
+	server  *server.Server
+	sources *sourceTracker
+	output  chan *config.Action
+	mux     *http.ServeMux
+	logger  *slog.Logger
+}
+
+// New returns a new admin API. If token is empty, the write endpoints are disabled.
+func New(addr, token string, h *handler.Handler, srv *server.Server, logger *slog.Logger) *API {
+	api := &API{
+		addr:    addr,
+		token:   token,
+		handler: h,
+		server:  srv,
+		sources: newSourceTracker(),
+		output:  make(chan *config.Action, 32),
+		mux:     http.NewServeMux(),
+		logger:  logger,
+	}
+	api.mux.HandleFunc("/lbrules", api.handleLbRules)
+	api.mux.HandleFunc("/lbrules/", api.handleLbRules)
+	api.mux.HandleFunc("/mwrules", api.handleMwRules)
+	api.mux.HandleFunc("/mwrules/", api.handleMwRules)
+	api.mux.HandleFunc("/loadbalancers", api.handleLoadbalancers)
+	api.mux.HandleFunc("/loadbalancers/", api.handleLoadbalancers)
+	api.mux.HandleFunc("/certs", api.handleCerts)
+	api.mux.HandleFunc("/certs/", api.handleCerts)
+	api.mux.HandleFunc("/sources", api.handleSources)
+	api.mux.HandleFunc("/loglevel", api.handleLogLevel)
+	api.mux.Handle("/metrics", promhttp.Handler())
+	return api
+}
+
+// GetChannel returns the channel of config actions submitted via the write endpoints
+func (api *API) GetChannel() chan *config.Action {
+	return api.output
+}
+
+// Track returns a config.Stream which forwards all actions of src
+// and records them under the given name for the /sources endpoint
+func (api *API) Track(name string, src config.Stream) config.Stream {
+	return api.sources.track(name, src)
+}
+
+// ListenAndServe starts the admin HTTP server
+func (api *API) ListenAndServe() error {
+	ln, err := net.Listen("tcp", api.addr)
+	if err != nil {
+		return err
+	}
+	go http.Serve(ln, api.mux)
+	api.logger.Info("started admin server", "addr", api.addr)
+	return nil
+}
+
+func (api *API) handleLbRules(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/lbrules"), "/")
+	if req.Method != http.MethodGet && !api.authorized(w, req) {
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		writeJSON(w, api.handler.LBManager.Rules())
+	case req.Method == http.MethodPut && id != "":
+		rule := &lbRule.Rule{}
+		if !readJSON(w, req, rule) {
+			return
+		}
+		rule.ID = id
+		api.submit(w, req, &config.Action{Type: config.UpsertLbRule, LbRule: rule})
+	case req.Method == http.MethodDelete && id != "":
+		api.submit(w, req, &config.Action{Type: config.DeleteLbRule, LbRule: &lbRule.Rule{ID: id}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (api *API) handleMwRules(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/mwrules"), "/")
+	if req.Method != http.MethodGet && !api.authorized(w, req) {
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		writeJSON(w, api.handler.MWManager.Rules())
+	case req.Method == http.MethodPut && id != "":
+		rule := &mwRule.Rule{}
+		if !readJSON(w, req, rule) {
+			return
+		}
+		rule.ID = id
+		api.submit(w, req, &config.Action{Type: config.UpsertMwRule, MwRule: rule})
+	case req.Method == http.MethodDelete && id != "":
+		api.submit(w, req, &config.Action{Type: config.DeleteMwRule, MwRule: &mwRule.Rule{ID: id}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// handleLoadbalancers serves /loadbalancers, /loadbalancers/<lb>/config and /loadbalancers/<lb>/hosts/<id>
+func (api *API) handleLoadbalancers(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/loadbalancers"), "/")
+	if path == "" {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		writeJSON(w, api.handler.LBManager.Loadbalancers())
+		return
+	}
+	if !api.authorized(w, req) {
+		return
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) == 2 && parts[0] != "" && parts[1] == "config" {
+		api.handleLoadbalancerConfig(w, req, parts[0])
+		return
+	}
+	if len(parts) != 3 || parts[1] != "hosts" || parts[0] == "" || parts[2] == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	switch req.Method {
+	case http.MethodPut:
+		cfg := &config.HostConfig{}
+		if !readJSON(w, req, cfg) {
+			return
+		}
+		cfg.ID = parts[2]
+		cfg.Loadbalancer = parts[0]
+		api.submit(w, req, &config.Action{Type: config.UpsertHost, HostConfig: cfg})
+	case http.MethodDelete:
+		cfg := &config.HostConfig{ID: parts[2], Loadbalancer: parts[0]}
+		api.submit(w, req, &config.Action{Type: config.DeleteHost, HostConfig: cfg})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (api *API) handleLoadbalancerConfig(w http.ResponseWriter, req *http.Request, id string) {
+	switch req.Method {
+	case http.MethodPut:
+		cfg := &config.LoadbalancerConfig{}
+		if !readJSON(w, req, cfg) {
+			return
+		}
+		cfg.ID = id
+		if cfg.TLS != nil {
+			if err := cfg.TLS.CheckSealed(); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		api.submit(w, req, &config.Action{Type: config.UpsertLbConfig, LbConfig: cfg})
+	case http.MethodDelete:
+		api.submit(w, req, &config.Action{Type: config.DeleteLbConfig, LbConfig: &config.LoadbalancerConfig{ID: id}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// handleCerts serves /certs. Certificates submitted via PUT must be
+// sealed with the eve password, just like the ones stored in etcd.
+func (api *API) handleCerts(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/certs"), "/")
+	if req.Method != http.MethodGet && !api.authorized(w, req) {
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		writeJSON(w, api.server.Certificates())
+	case req.Method == http.MethodPut && id != "":
+		cfg := &config.CertConfig{}
+		if !readJSON(w, req, cfg) {
+			return
+		}
+		cfg.ID = id
+		if err := cfg.CheckSealed(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		api.submit(w, req, &config.Action{Type: config.UpsertCert, CertConfig: cfg})
+	case req.Method == http.MethodDelete && id != "":
+		api.submit(w, req, &config.Action{Type: config.DeleteCert, CertConfig: &config.CertConfig{ID: id}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (api *API) handleSources(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	writeJSON(w, api.sources.status())
+}
+
+// handleLogLevel serves /loglevel. The level is sent as plain text.
+func (api *API) handleLogLevel(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, logging.Level.Level().String())
+	case http.MethodPut:
+		if !api.authorized(w, req) {
+			return
+		}
+		bs, err := io.ReadAll(io.LimitReader(req.Body, 64))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		api.submit(w, req, &config.Action{Type: config.SetLogLevel, LogLevel: string(bs)})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// authorized checks the bearer token of write requests
+func (api *API) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if api.token == "" {
+		writeError(w, http.StatusForbidden, errors.New("write access is disabled"))
+		return false
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("missing bearer token"))
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return false
+	}
+	return true
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func (api *API) submit(w http.ResponseWriter, req *http.Request, action *config.Action) {
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+	select {
+	case api.output <- action:
+		w.WriteHeader(http.StatusAccepted)
+	case <-ctx.Done():
+		writeError(w, http.StatusServiceUnavailable, errors.New("config queue is full"))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()})
+}
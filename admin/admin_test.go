@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/trusch/eve/handler"
+	_ "github.com/trusch/eve/middleware/builtin"
+	mwManager "github.com/trusch/eve/middleware/manager"
+	mwRule "github.com/trusch/eve/middleware/rule"
+)
+
+func TestMwRulesRedactsSecrets(t *testing.T) {
+	mgr := mwManager.New(slog.Default())
+	api := New("127.0.0.1:0", "", &handler.Handler{MWManager: mgr}, nil, slog.Default())
+	oidcOpts := map[string]interface{}{
+		"Issuer":       "https://idp.test",
+		"ClientID":     "app",
+		"ClientSecret": "client-secret-value",
+		// option names are case insensitive
+		"cookiesecret": "cookie-secret-value",
+	}
+	err := mgr.UpsertRule(mwRule.New("auth", `Host("app.test")`, []*mwRule.Config{
+		{ID: "oidc", Opts: oidcOpts},
+		{ID: "jwt", Opts: map[string]interface{}{"Secret": "jwt-secret-value", "Issuer": "https://idp.test"}},
+		{ID: "basicauth", Opts: map[string]interface{}{"Users": []interface{}{"alice:$2y$05$hash-value"}}},
+		{ID: "cors", Opts: map[string]interface{}{"AllowedOrigins": []interface{}{"https://app.test"}}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	api.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mwrules", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want 200", w.Code)
+	}
+	body := w.Body.String()
+	for _, secret := range []string{"client-secret-value", "cookie-secret-value", "jwt-secret-value", "hash-value"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("GET /mwrules returned %q: %v", secret, body)
+		}
+	}
+	rules := []*mwRule.Rule{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil || len(rules) != 1 {
+		t.Fatalf("unexpected body %v: %v", body, err)
+	}
+	got := rules[0].Middlewares[0].Opts.(map[string]interface{})
+	if got["ClientSecret"] != "<redacted>" || got["cookiesecret"] != "<redacted>" || got["ClientID"] != "app" {
+		t.Errorf("unexpected oidc options %v", got)
+	}
+	if !strings.Contains(body, "https://app.test") {
+		t.Errorf("GET /mwrules misses the cors options: %v", body)
+	}
+	// the redaction must not touch the options the middlewares are built from
+	if oidcOpts["ClientSecret"] != "client-secret-value" {
+		t.Errorf("stored options were redacted")
+	}
+}
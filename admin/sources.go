@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/config"
+)
+
+// SourceStatus describes a config source and its activity
+type SourceStatus struct {
+	Name      string
+	Events    int
+	LastEvent time.Time
+}
+
+type sourceTracker struct {
+	mutex   sync.RWMutex
+	sources map[string]*SourceStatus
+}
+
+type trackedStream struct {
+	output chan *config.Action
+}
+
+func (stream *trackedStream) GetChannel() chan *config.Action {
+	return stream.output
+}
+
+func newSourceTracker() *sourceTracker {
+	return &sourceTracker{sources: make(map[string]*SourceStatus)}
+}
+
+func (tracker *sourceTracker) track(name string, src config.Stream) config.Stream {
+	tracker.mutex.Lock()
+	tracker.sources[name] = &SourceStatus{Name: name}
+	tracker.mutex.Unlock()
+	stream := &trackedStream{output: make(chan *config.Action, 32)}
+	go func() {
+		for action := range src.GetChannel() {
+			tracker.mutex.Lock()
+			status := tracker.sources[name]
+			status.Events++
+			status.LastEvent = time.Now()
+			tracker.mutex.Unlock()
+			stream.output <- action
+		}
+		close(stream.output)
+	}()
+	return stream
+}
+
+func (tracker *sourceTracker) status() []*SourceStatus {
+	tracker.mutex.RLock()
+	defer tracker.mutex.RUnlock()
+	result := make([]*SourceStatus, 0, len(tracker.sources))
+	for _, status := range tracker.sources {
+		s := *status
+		result = append(result, &s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
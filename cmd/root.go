@@ -21,17 +21,28 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/trusch/eve/accesslog"
+	"github.com/trusch/eve/admin"
 	"github.com/trusch/eve/config"
 	"github.com/trusch/eve/config/docker"
 	"github.com/trusch/eve/config/etcd"
 	"github.com/trusch/eve/handler"
+	"github.com/trusch/eve/logging"
+	"github.com/trusch/eve/metrics"
+	"github.com/trusch/eve/middleware/kv"
 	"github.com/trusch/eve/server"
+	"github.com/trusch/eve/tracing"
 )
 
 var cfgFile string
@@ -47,46 +58,105 @@ You can use it to proxy http connections in your container cluster with minimal
 		httpAddr := viper.GetString("http")
 		httpsAddr := viper.GetString("https")
 
-		h := handler.New()
+		logger, err := logging.New(os.Stderr, viper.GetString("log-format"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		slog.SetDefault(logger)
+		if err = logging.SetLevel(viper.GetString("log-level")); err != nil {
+			fatal(logger, "invalid log level", err)
+		}
+
+		shutdownTracing, err := tracing.Setup(tracing.Config{
+			Exporter:   viper.GetString("tracing-exporter"),
+			Endpoint:   viper.GetString("tracing-endpoint"),
+			Insecure:   viper.GetBool("tracing-insecure"),
+			File:       viper.GetString("tracing-file"),
+			SampleRate: viper.GetFloat64("tracing-sample-rate"),
+		})
+		if err != nil {
+			fatal(logger, "failed to setup tracing", err)
+		}
+
+		h := handler.New(logger)
+		if output := viper.GetString("access-log"); output != "" {
+			h.AccessLog, err = accesslog.New(&accesslog.Config{
+				Output:     output,
+				Format:     viper.GetString("access-log-format"),
+				Fields:     viper.GetStringSlice("access-log-fields"),
+				MaxSize:    viper.GetInt("access-log-max-size"),
+				MaxBackups: viper.GetInt("access-log-max-backups"),
+				MaxAge:     viper.GetInt("access-log-max-age"),
+			})
+			if err != nil {
+				fatal(logger, "failed to open access log", err)
+			}
+		}
 
-		srv, err := server.New(h, httpAddr, httpsAddr)
+		srv, err := server.New(h, httpAddr, httpsAddr, logger.With("component", "server"))
 		if err != nil {
-			log.Fatal(err)
+			fatal(logger, "failed to create server", err)
+		}
+		if err = srv.SetProxyProtocol(viper.GetStringSlice("proxy-protocol")); err != nil {
+			fatal(logger, "invalid --proxy-protocol networks", err)
 		}
 		err = srv.ListenAndServeHTTP()
 		if err != nil {
-			log.Fatal(err)
+			fatal(logger, "failed to start HTTP server", err)
 		}
 		err = srv.ListenAndServeHTTPS()
 		if err != nil {
-			log.Fatal(err)
+			fatal(logger, "failed to start HTTPS server", err)
+		}
+
+		api := admin.New(viper.GetString("admin"), viper.GetString("admin-token"), h, srv, logger.With("component", "admin"))
+		if viper.GetString("admin") != "" {
+			if err = api.ListenAndServe(); err != nil {
+				fatal(logger, "failed to start admin server", err)
+			}
 		}
 
 		configSrcConfigured := false
+		startSource := func(name string, src config.Stream) {
+			configSrcConfigured = true
+			go supplyConfig(name, api.Track(name, src), h, srv, logger)
+		}
 
 		etcdAddr := viper.GetString("etcd")
 		if etcdAddr != "" {
-			cli, err := etcd.NewClient(etcdAddr)
+			cli, err := etcd.NewClient(etcdAddr, viper.GetString("log-level"), logger)
 			if err != nil {
-				log.Print(err)
+				logger.Error("failed to connect to etcd", "addr", etcdAddr, "error", err)
 			} else {
-				configSrcConfigured = true
-				go supplyConfig(cli, h, srv)
+				kv.Set(cli)
+				startSource("etcd", cli)
 			}
 		}
 		if viper.GetBool("docker") {
-			cli, err := docker.New()
+			cli, err := docker.New(logger)
 			if err != nil {
-				log.Print(err)
+				logger.Error("failed to connect to docker", "error", err)
 			} else {
-				configSrcConfigured = true
-				go supplyConfig(cli, h, srv)
+				startSource("docker", cli)
 			}
 		}
+		if viper.GetString("admin") != "" && viper.GetString("admin-token") != "" {
+			startSource("admin", api)
+		}
 		if !configSrcConfigured {
-			log.Fatal("specify at least one config source: --docker or --etcd='<etcd-address>'")
+			fatal(logger, "no config source", errors.New("specify at least one config source: --docker, --etcd='<etcd-address>' or --admin-token"))
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		logger.Info("shutting down", "signal", sig.String())
+		// flush the spans which are still batched
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn("failed to flush traces", "error", err)
 		}
-		select {}
 	},
 }
 
@@ -105,6 +175,22 @@ func init() {
 	RootCmd.Flags().String("etcd", "127.0.0.1:2379", "etcd server address")
 	RootCmd.Flags().Bool("docker", false, "listen for docker events")
 	RootCmd.Flags().String("password", "", "certificate seal password")
+	RootCmd.Flags().String("admin", "127.0.0.1:8081", "admin API address (empty to disable)")
+	RootCmd.Flags().String("admin-token", "", "bearer token which enables the admin API write endpoints")
+	RootCmd.Flags().String("tracing-exporter", "", "trace exporter: otlp or file (empty to disable tracing)")
+	RootCmd.Flags().String("tracing-endpoint", "localhost:4318", "OTLP/HTTP collector address")
+	RootCmd.Flags().Bool("tracing-insecure", false, "disable TLS for the OTLP exporter")
+	RootCmd.Flags().String("tracing-file", "/dev/stdout", "output path of the file trace exporter")
+	RootCmd.Flags().Float64("tracing-sample-rate", 1, "default trace sample rate, can be overridden per loadbalancer rule")
+	RootCmd.Flags().String("access-log", "", "global access log output: stdout, stderr, syslog, syslog://host:port, udp://host:port or a file path (empty to disable)")
+	RootCmd.Flags().String("access-log-format", "json", "access log format: json, common or combined")
+	RootCmd.Flags().StringSlice("access-log-fields", nil, "fields of json access log entries (default all)")
+	RootCmd.Flags().Int("access-log-max-size", 100, "size in megabytes after which the access log file is rotated")
+	RootCmd.Flags().Int("access-log-max-backups", 0, "number of rotated access log files to keep (0 keeps all)")
+	RootCmd.Flags().Int("access-log-max-age", 0, "number of days to keep rotated access log files (0 keeps all)")
+	RootCmd.Flags().String("log-level", "info", "log level: debug, info, warn or error (can be changed at runtime with eve-ctl loglevel)")
+	RootCmd.Flags().StringSlice("proxy-protocol", nil, "networks of proxies which send PROXY protocol headers (empty to disable)")
+	RootCmd.Flags().String("log-format", "text", "log format: text or json")
 	RootCmd.Flags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.eve.yaml)")
 	viper.BindPFlags(RootCmd.Flags())
 }
@@ -124,60 +210,94 @@ func initConfig() {
 	}
 }
 
-func supplyConfig(src config.Stream, handler *handler.Handler, srv *server.Server) {
+// fatal logs err and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func supplyConfig(name string, src config.Stream, handler *handler.Handler, srv *server.Server, logger *slog.Logger) {
 	for action := range src.GetChannel() {
+		metrics.ConfigActions.WithLabelValues(name, action.Type.String()).Inc()
+		actionLogger := logger.With("source", name, "action", action.Type.String())
+		var err error
 		switch action.Type {
 		case config.UpsertLbRule:
 			{
-				log.Print("upsert lb rule: ", action.LbRule)
-				handler.LBManager.UpsertRule(action.LbRule)
+				actionLogger = actionLogger.With("id", action.LbRule.ID, "route", action.LbRule.Route, "target", action.LbRule.Target)
+				err = handler.LBManager.UpsertRule(action.LbRule)
 			}
 		case config.UpsertMwRule:
 			{
-				log.Print("upsert mw rule: ", action.MwRule)
-				handler.MWManager.UpsertRule(action.MwRule)
+				actionLogger = actionLogger.With("id", action.MwRule.ID, "route", action.MwRule.Route)
+				err = handler.MWManager.UpsertRule(action.MwRule)
 			}
 		case config.UpsertHost:
 			{
-				log.Print("upsert host: ", action.HostConfig)
-				handler.LBManager.UpsertServer(action.HostConfig)
+				actionLogger = actionLogger.With("id", action.HostConfig.ID, "loadbalancer", action.HostConfig.Loadbalancer, "url", action.HostConfig.URL)
+				err = handler.LBManager.UpsertServer(action.HostConfig)
 			}
 		case config.UpsertCert:
 			{
-				log.Print("upsert cert: ", action.CertConfig.ID)
+				actionLogger = actionLogger.With("id", action.CertConfig.ID)
 				cfg := action.CertConfig
-				if err := cfg.Decrypt(viper.GetString("password")); err != nil {
-					log.Print(err)
-					continue
+				if err = cfg.Decrypt(viper.GetString("password")); err != nil {
+					break
 				}
-				srv.AddCertificate(cfg.ID, cfg.CertPem, cfg.KeyPem)
-				if err := srv.ListenAndServeHTTPS(); err != nil {
-					log.Print(err)
+				if err = srv.AddCertificate(cfg.ID, cfg.CertPem, cfg.KeyPem); err != nil {
+					break
 				}
+				err = srv.ListenAndServeHTTPS()
 			}
 		case config.DeleteLbRule:
 			{
-				log.Print("delete lb rule: ", action.LbRule)
-				handler.LBManager.RemoveRule(action.LbRule.ID)
+				actionLogger = actionLogger.With("id", action.LbRule.ID)
+				err = handler.LBManager.RemoveRule(action.LbRule.ID)
 			}
 		case config.DeleteMwRule:
 			{
-				log.Print("delete mw rule: ", action.MwRule)
-				handler.MWManager.RemoveRule(action.MwRule.ID)
+				actionLogger = actionLogger.With("id", action.MwRule.ID)
+				err = handler.MWManager.RemoveRule(action.MwRule.ID)
 			}
 		case config.DeleteHost:
 			{
-				log.Print("delete host: ", action.HostConfig)
-				handler.LBManager.RemoveServer(action.HostConfig)
+				actionLogger = actionLogger.With("id", action.HostConfig.ID, "loadbalancer", action.HostConfig.Loadbalancer)
+				err = handler.LBManager.RemoveServer(action.HostConfig)
 			}
 		case config.DeleteCert:
 			{
-				log.Print("delete cert: ", action.CertConfig)
-				srv.RemoveCertificate(action.CertConfig.ID)
-				if err := srv.ListenAndServeHTTPS(); err != nil {
-					log.Print(err)
+				actionLogger = actionLogger.With("id", action.CertConfig.ID)
+				if err = srv.RemoveCertificate(action.CertConfig.ID); err != nil {
+					break
+				}
+				err = srv.ListenAndServeHTTPS()
+			}
+		case config.UpsertLbConfig:
+			{
+				actionLogger = actionLogger.With("id", action.LbConfig.ID)
+				cfg := action.LbConfig
+				if cfg.TLS != nil {
+					if err = cfg.TLS.Decrypt(viper.GetString("password")); err != nil {
+						break
+					}
 				}
+				err = handler.LBManager.UpsertLoadbalancer(cfg)
+			}
+		case config.DeleteLbConfig:
+			{
+				actionLogger = actionLogger.With("id", action.LbConfig.ID)
+				err = handler.LBManager.RemoveLoadbalancer(action.LbConfig.ID)
 			}
+		case config.SetLogLevel:
+			{
+				actionLogger = actionLogger.With("level", action.LogLevel)
+				err = logging.SetLevel(action.LogLevel)
+			}
+		}
+		if err != nil {
+			actionLogger.Error("failed to apply config action", "error", err)
+			continue
 		}
+		actionLogger.Info("applied config action")
 	}
 }
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+
 	lbRule "github.com/trusch/eve/loadbalancer/rule"
 	mwRule "github.com/trusch/eve/middleware/rule"
 )
@@ -17,6 +19,8 @@ type Action struct {
 	MwRule     *mwRule.Rule
 	HostConfig *HostConfig
 	CertConfig *CertConfig
+	LbConfig   *LoadbalancerConfig
+	LogLevel   string
 }
 
 // HostConfig represents the registration of a host
@@ -24,6 +28,9 @@ type HostConfig struct {
 	ID           string
 	Loadbalancer string
 	URL          string
+	// Priority is the tier of the host. Tier 0 is the primary one,
+	// higher tiers only get traffic if the lower ones lack healthy capacity.
+	Priority int `json:",omitempty"`
 }
 
 // CertConfig represents a certificate
@@ -53,18 +60,71 @@ const (
 	DeleteCert
 	// DeleteHost represents the request to delete a host from a loadbalancer
 	DeleteHost
+	// SetLogLevel represents the request to change the log level
+	SetLogLevel
+	// UpsertLbConfig represents the request to upsert the config of a loadbalancer
+	UpsertLbConfig
+	// DeleteLbConfig represents the request to reset the config of a loadbalancer to the defaults
+	DeleteLbConfig
 )
 
+var actionTypeNames = [...]string{
+	UpsertLbRule:   "UpsertLbRule",
+	UpsertMwRule:   "UpsertMwRule",
+	UpsertCert:     "UpsertCert",
+	UpsertHost:     "UpsertHost",
+	DeleteLbRule:   "DeleteLbRule",
+	DeleteMwRule:   "DeleteMwRule",
+	DeleteCert:     "DeleteCert",
+	DeleteHost:     "DeleteHost",
+	SetLogLevel:    "SetLogLevel",
+	UpsertLbConfig: "UpsertLbConfig",
+	DeleteLbConfig: "DeleteLbConfig",
+}
+
+// String returns the name of the action type
+func (t ActionType) String() string {
+	if t < 0 || int(t) >= len(actionTypeNames) {
+		return "Unknown"
+	}
+	return actionTypeNames[t]
+}
+
 // Encrypt seals the cert config with a password
 func (cfg *CertConfig) Encrypt(password string) error {
-	cfg.CertPem = encrypt(cfg.CertPem, password)
-	cfg.KeyPem = encrypt(cfg.KeyPem, password)
+	certPem, err := encrypt(cfg.CertPem, password)
+	if err != nil {
+		return fmt.Errorf("cert: %v", err)
+	}
+	keyPem, err := encrypt(cfg.KeyPem, password)
+	if err != nil {
+		return fmt.Errorf("key: %v", err)
+	}
+	cfg.CertPem, cfg.KeyPem = certPem, keyPem
 	return nil
 }
 
 // Decrypt decrypts a sealed cert config
 func (cfg *CertConfig) Decrypt(password string) error {
-	cfg.CertPem = decrypt(cfg.CertPem, password)
-	cfg.KeyPem = decrypt(cfg.KeyPem, password)
+	certPem, err := decrypt(cfg.CertPem, password)
+	if err != nil {
+		return fmt.Errorf("cert: %v", err)
+	}
+	keyPem, err := decrypt(cfg.KeyPem, password)
+	if err != nil {
+		return fmt.Errorf("key: %v", err)
+	}
+	cfg.CertPem, cfg.KeyPem = certPem, keyPem
+	return nil
+}
+
+// CheckSealed checks that the cert config looks sealed, so it can be rejected before it is applied
+func (cfg *CertConfig) CheckSealed() error {
+	if _, err := checkSealed(cfg.CertPem); err != nil {
+		return fmt.Errorf("cert: %v", err)
+	}
+	if _, err := checkSealed(cfg.KeyPem); err != nil {
+		return fmt.Errorf("key: %v", err)
+	}
 	return nil
 }
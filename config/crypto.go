@@ -5,6 +5,8 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/sha3"
@@ -14,15 +16,15 @@ import (
  * https://gist.github.com/josephspurrier/12cc5ed76d2228a41ceb
  */
 
-func decrypt(cipherstring string, keystring string) string {
-	ciphertext, _ := base64.StdEncoding.DecodeString(cipherstring)
+func decrypt(cipherstring string, keystring string) (string, error) {
+	ciphertext, err := checkSealed(cipherstring)
+	if err != nil {
+		return "", err
+	}
 	key := sha3.Sum256([]byte(keystring))
 	block, err := aes.NewCipher(key[:])
 	if err != nil {
-		panic(err)
-	}
-	if len(ciphertext) < aes.BlockSize {
-		panic("Text is too short")
+		return "", err
 	}
 
 	iv := ciphertext[:aes.BlockSize]
@@ -30,25 +32,37 @@ func decrypt(cipherstring string, keystring string) string {
 	stream := cipher.NewCFBDecrypter(block, iv)
 	stream.XORKeyStream(ciphertext, ciphertext)
 
-	return string(ciphertext)
+	return string(ciphertext), nil
+}
+
+// checkSealed decodes a value sealed by encrypt. It can't tell whether the password is right.
+func checkSealed(cipherstring string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(cipherstring)
+	if err != nil {
+		return nil, fmt.Errorf("sealed value is not base64: %v", err)
+	}
+	if len(ciphertext) < aes.BlockSize {
+		return nil, errors.New("sealed value is too short")
+	}
+	return ciphertext, nil
 }
 
-func encrypt(plainstring, keystring string) string {
+func encrypt(plainstring, keystring string) (string, error) {
 	plaintext := []byte(plainstring)
 	key := sha3.Sum256([]byte(keystring))
 	block, err := aes.NewCipher(key[:])
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
 	iv := ciphertext[:aes.BlockSize]
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		panic(err)
+		return "", err
 	}
 
 	stream := cipher.NewCFBEncrypter(block, iv)
 	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
 
-	return base64.StdEncoding.EncodeToString(ciphertext)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
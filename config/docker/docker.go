@@ -3,7 +3,7 @@ package docker
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
@@ -15,10 +15,11 @@ import (
 type ConfigSource struct {
 	cli    *client.Client
 	output chan *config.Action
+	logger *slog.Logger
 }
 
 // New creates a new ConfigSource
-func New() (*ConfigSource, error) {
+func New(logger *slog.Logger) (*ConfigSource, error) {
 	c, err := client.NewEnvClient()
 	if err != nil {
 		return nil, err
@@ -26,6 +27,7 @@ func New() (*ConfigSource, error) {
 	res := &ConfigSource{
 		cli:    c,
 		output: make(chan *config.Action, 32),
+		logger: logger.With("source", "docker"),
 	}
 	containers, err := c.ContainerList(context.Background(), types.ContainerListOptions{})
 	if err != nil {
@@ -46,7 +48,12 @@ func (src *ConfigSource) GetChannel() chan *config.Action {
 }
 
 func (src *ConfigSource) backend() {
-	events, _ := src.cli.Events(context.Background(), types.EventsOptions{})
+	events, errs := src.cli.Events(context.Background(), types.EventsOptions{})
+	go func() {
+		for err := range errs {
+			src.logger.Error("docker event stream failed", "error", err)
+		}
+	}()
 	for event := range events {
 		if event.Action == "start" {
 			if host := checkForeveHostLabel(event.Actor.Attributes); host != "" {
@@ -80,7 +87,7 @@ func (src *ConfigSource) getIP(id string) (string, error) {
 func (src *ConfigSource) handleStart(id string, host string) {
 	ip, err := src.getIP(id)
 	if err != nil {
-		log.Print(err)
+		src.logger.Error("failed to inspect container", "id", id, "error", err)
 		return
 	}
 	src.output <- &config.Action{
@@ -3,7 +3,7 @@ package etcd
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -19,10 +19,14 @@ type Client struct {
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 	output     chan *config.Action
+	logger     *slog.Logger
+	// defaultLogLevel is restored when the log level key is deleted
+	defaultLogLevel string
 }
 
-// NewClient returns a new etcd client
-func NewClient(etcdAddr string) (*Client, error) {
+// NewClient returns a new etcd client.
+// defaultLogLevel is applied when the log level key is deleted.
+func NewClient(etcdAddr string, defaultLogLevel string, logger *slog.Logger) (*Client, error) {
 	cli, err := clientv3.New(clientv3.Config{
 		Endpoints:   []string{etcdAddr},
 		DialTimeout: 3 * time.Second,
@@ -31,16 +35,25 @@ func NewClient(etcdAddr string) (*Client, error) {
 		return nil, errors.New("can not connect to etcd")
 	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
-	client := &Client{v3: cli, output: make(chan *config.Action, 32)}
+	client := &Client{
+		v3:              cli,
+		output:          make(chan *config.Action, 32),
+		logger:          logger.With("source", "etcd"),
+		defaultLogLevel: defaultLogLevel,
+	}
 	client.ctx = ctx
 	client.cancelFunc = cancelFunc
 	resp, err := cli.Grant(ctx, 5)
 	if err != nil {
-		log.Fatal(err)
+		cancelFunc()
+		cli.Close()
+		return nil, err
 	}
 	client.leaseID = resp.ID
 	if _, err := cli.KeepAlive(ctx, resp.ID); err != nil {
-		log.Fatal(err)
+		cancelFunc()
+		cli.Close()
+		return nil, err
 	}
 	go client.backend()
 	return client, nil
@@ -58,39 +71,54 @@ func (client *Client) Close() {
 }
 
 func (client *Client) backend() {
+	lbConfigs, err := client.GetLoadbalancerConfigs()
+	if err != nil {
+		client.logger.Error("failed to list loadbalancer configs", "error", err)
+	}
+	for _, cfg := range lbConfigs {
+		client.feedUpsertLbConfigToChannel(cfg)
+	}
 	lbRules, err := client.GetLoadbalancerRules()
 	if err != nil {
-		log.Print(err)
+		client.logger.Error("failed to list loadbalancer rules", "error", err)
 	}
 	for _, rule := range lbRules {
 		client.feedUpsertLbRuleToChannel(rule)
 	}
 	mwRules, err := client.GetMiddlewareRules()
 	if err != nil {
-		log.Print(err)
+		client.logger.Error("failed to list middleware rules", "error", err)
 	}
 	for _, rule := range mwRules {
 		client.feedUpsertMwRuleToChannel(rule)
 	}
 	hostCfgs, err := client.GetHostConfigs()
 	if err != nil {
-		log.Print(err)
+		client.logger.Error("failed to list hosts", "error", err)
 	}
 	for _, cfg := range hostCfgs {
 		client.feedUpsertHostToChannel(cfg)
 	}
 	certCfgs, err := client.GetCertConfigs()
 	if err != nil {
-		log.Print(err)
+		client.logger.Error("failed to list certificates", "error", err)
 	}
 	for _, cfg := range certCfgs {
 		client.feedUpsertCertToChannel(cfg)
 	}
+	logLevel, err := client.GetLogLevel()
+	if err != nil {
+		client.logger.Error("failed to get log level", "error", err)
+	} else if logLevel != "" {
+		client.feedSetLogLevelToChannel(logLevel)
+	}
 
+	go client.watchLbConfigs()
 	go client.watchLbRules()
 	go client.watchMwRules()
 	go client.watchHosts()
 	go client.watchCerts()
+	go client.watchLogLevel()
 
 }
 
@@ -149,3 +177,24 @@ func (client *Client) feedDeleteCertToChannel(cfg *config.CertConfig) {
 		CertConfig: cfg,
 	}
 }
+
+func (client *Client) feedUpsertLbConfigToChannel(cfg *config.LoadbalancerConfig) {
+	client.output <- &config.Action{
+		Type:     config.UpsertLbConfig,
+		LbConfig: cfg,
+	}
+}
+
+func (client *Client) feedDeleteLbConfigToChannel(cfg *config.LoadbalancerConfig) {
+	client.output <- &config.Action{
+		Type:     config.DeleteLbConfig,
+		LbConfig: cfg,
+	}
+}
+
+func (client *Client) feedSetLogLevelToChannel(level string) {
+	client.output <- &config.Action{
+		Type:     config.SetLogLevel,
+		LogLevel: level,
+	}
+}
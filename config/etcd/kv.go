@@ -0,0 +1,76 @@
+package etcd
+
+import (
+	"context"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// The client implements kv.Store, so middlewares can keep their settings and shared state in etcd.
+
+// Get returns the value of a key and whether it exists
+func (client *Client) Get(ctx context.Context, key string) (string, bool, error) {
+	resp, err := client.v3.Get(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+// List returns all keys with the given prefix and their values
+func (client *Client) List(ctx context.Context, prefix string) (map[string]string, error) {
+	resp, err := client.v3.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		result[string(kv.Key)] = string(kv.Value)
+	}
+	return result, nil
+}
+
+// Put sets a key. Values which are not persistent are bound to the lease of this instance.
+func (client *Client) Put(ctx context.Context, key, value string, persistent bool) error {
+	if persistent {
+		_, err := client.v3.Put(ctx, key, value)
+		return err
+	}
+	_, err := client.v3.Put(ctx, key, value, clientv3.WithLease(client.leaseID))
+	return err
+}
+
+// Delete removes a key
+func (client *Client) Delete(ctx context.Context, key string) error {
+	_, err := client.v3.Delete(ctx, key)
+	return err
+}
+
+// Watch sends the new value of a key on every change, "" if it is deleted
+func (client *Client) Watch(ctx context.Context, key string) <-chan string {
+	output := make(chan string, 1)
+	go func() {
+		defer close(output)
+		for wresp := range client.v3.Watch(ctx, key) {
+			if err := wresp.Err(); err != nil {
+				client.logger.Warn("watch failed", "key", key, "error", err)
+				continue
+			}
+			for _, ev := range wresp.Events {
+				value := ""
+				if ev.Type == clientv3.EventTypePut {
+					value = string(ev.Kv.Value)
+				}
+				select {
+				case output <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return output
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/coreos/etcd/clientv3"
@@ -25,7 +24,7 @@ func (client *Client) GetLoadbalancerRules() ([]*lbRule.Rule, error) {
 	for _, kv := range resp.Kvs {
 		rule, err := client.parseLbRule(kv)
 		if err != nil {
-			log.Print("Error: ", err)
+			client.logger.Warn("skipping malformed loadbalancer rule", "key", string(kv.Key), "error", err)
 			continue
 		}
 		rules = append(rules, rule)
@@ -43,7 +42,7 @@ func (client *Client) GetMiddlewareRules() ([]*mwRule.Rule, error) {
 	for _, kv := range resp.Kvs {
 		rule, err := client.parseMwRule(kv)
 		if err != nil {
-			log.Print("Error: ", err)
+			client.logger.Warn("skipping malformed middleware rule", "key", string(kv.Key), "error", err)
 			continue
 		}
 		rules = append(rules, rule)
@@ -61,7 +60,7 @@ func (client *Client) GetHostConfigs() ([]*config.HostConfig, error) {
 	for _, kv := range resp.Kvs {
 		cfg, err := client.parseHostConfig(kv)
 		if err != nil {
-			log.Print("Error: ", err)
+			client.logger.Warn("skipping malformed host", "key", string(kv.Key), "error", err)
 			continue
 		}
 		cfgs = append(cfgs, cfg)
@@ -79,7 +78,7 @@ func (client *Client) GetCertConfigs() ([]*config.CertConfig, error) {
 	for _, kv := range resp.Kvs {
 		cfg, err := client.parseCertConfig(kv)
 		if err != nil {
-			log.Print("Error: ", err)
+			client.logger.Warn("skipping malformed certificate", "key", string(kv.Key), "error", err)
 			continue
 		}
 		cfgs = append(cfgs, cfg)
@@ -87,6 +86,36 @@ func (client *Client) GetCertConfigs() ([]*config.CertConfig, error) {
 	return cfgs, nil
 }
 
+// GetLoadbalancerConfigs returns a slice of all loadbalancer configs
+func (client *Client) GetLoadbalancerConfigs() ([]*config.LoadbalancerConfig, error) {
+	resp, err := client.v3.Get(client.ctx, "/eve/lbconfigs", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	cfgs := make([]*config.LoadbalancerConfig, 0, resp.Count)
+	for _, kv := range resp.Kvs {
+		cfg, err := client.parseLbConfig(kv)
+		if err != nil {
+			client.logger.Warn("skipping malformed loadbalancer config", "key", string(kv.Key), "error", err)
+			continue
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// GetLogLevel returns the configured log level, empty if none is set
+func (client *Client) GetLogLevel() (string, error) {
+	resp, err := client.v3.Get(client.ctx, "/eve/loglevel")
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
 func (client *Client) parseLbRule(kv *mvccpb.KeyValue) (*lbRule.Rule, error) {
 	rule := &lbRule.Rule{}
 	err := json.Unmarshal(kv.Value, rule)
@@ -117,15 +146,29 @@ func (client *Client) parseCertConfig(kv *mvccpb.KeyValue) (*config.CertConfig,
 	return cfg, nil
 }
 
+func (client *Client) parseLbConfig(kv *mvccpb.KeyValue) (*config.LoadbalancerConfig, error) {
+	cfg := &config.LoadbalancerConfig{}
+	err := json.Unmarshal(kv.Value, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing loadbalancer config: %v", err)
+	}
+	cfg.ID = string(kv.Key[len("/eve/lbconfigs/"):])
+	return cfg, nil
+}
+
 func (client *Client) parseHostConfig(kv *mvccpb.KeyValue) (*config.HostConfig, error) {
 	// /eve/loadbalancer/example-lb/hosts/foobar http://123.123.123.123:8080
 	parts := strings.Split(string(kv.Key), "/")
 	if len(parts) != 6 {
 		return nil, errors.New("malformed key")
 	}
-	return &config.HostConfig{
-		ID:           parts[5],
-		Loadbalancer: parts[3],
-		URL:          string(kv.Value),
-	}, nil
+	cfg := &config.HostConfig{URL: string(kv.Value)}
+	if strings.HasPrefix(cfg.URL, "{") {
+		if err := json.Unmarshal(kv.Value, cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg.ID = parts[5]
+	cfg.Loadbalancer = parts[3]
+	return cfg, nil
 }
@@ -37,6 +37,14 @@ func (client *Client) PutMwRule(rule *mwRule.Rule, persistent bool) error {
 func (client *Client) PutHostConfig(cfg *config.HostConfig, persistent bool) error {
 	key := fmt.Sprintf("/eve/loadbalancer/%v/hosts/%v", cfg.Loadbalancer, cfg.ID)
 	val := cfg.URL
+	if cfg.Priority != 0 {
+		// hosts with settings are stored as json, plain hosts as url for compatibility
+		bs, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		val = string(bs)
+	}
 	return client.put(key, val, persistent)
 
 }
@@ -52,6 +60,22 @@ func (client *Client) PutCertConfig(cfg *config.CertConfig, persistent bool) err
 	return client.put(key, val, persistent)
 }
 
+// PutLbConfig sets a loadbalancer config
+func (client *Client) PutLbConfig(cfg *config.LoadbalancerConfig, persistent bool) error {
+	key := fmt.Sprintf("/eve/lbconfigs/%v", cfg.ID)
+	bs, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	val := string(bs)
+	return client.put(key, val, persistent)
+}
+
+// PutLogLevel sets the log level of all eve instances
+func (client *Client) PutLogLevel(level string) error {
+	return client.put("/eve/loglevel", level, true)
+}
+
 // DelLbRule deletes a loadbalancer rule
 func (client *Client) DelLbRule(id string) error {
 	key := fmt.Sprintf("/eve/lbrules/%v", id)
@@ -76,6 +100,12 @@ func (client *Client) DelCertConfig(id string) error {
 	return client.del(key)
 }
 
+// DelLbConfig deletes a loadbalancer config
+func (client *Client) DelLbConfig(id string) error {
+	key := fmt.Sprintf("/eve/lbconfigs/%v", id)
+	return client.del(key)
+}
+
 func (client *Client) put(key, val string, persistent bool) error {
 	if persistent {
 		_, err := client.v3.Put(client.ctx, key, val)
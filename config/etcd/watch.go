@@ -1,23 +1,27 @@
 package etcd
 
 import (
-	"log"
-
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/trusch/eve/config"
 	lbRule "github.com/trusch/eve/loadbalancer/rule"
+	"github.com/trusch/eve/metrics"
 	mwRule "github.com/trusch/eve/middleware/rule"
 )
 
 func (client *Client) watchLbRules() {
 	rch := client.v3.Watch(client.ctx, "/eve/lbrules", clientv3.WithPrefix())
+	metrics.EtcdWatchUp.WithLabelValues("/eve/lbrules").Set(1)
+	defer metrics.EtcdWatchUp.WithLabelValues("/eve/lbrules").Set(0)
 	for wresp := range rch {
+		if !client.watchHealthy("/eve/lbrules", wresp) {
+			continue
+		}
 		for _, ev := range wresp.Events {
 			if ev.Type == mvccpb.PUT {
 				rule, err := client.parseLbRule(ev.Kv)
 				if err != nil {
-					log.Print(err)
+					client.logger.Warn("skipping malformed key", "key", string(ev.Kv.Key), "error", err)
 					continue
 				}
 				client.feedUpsertLbRuleToChannel(rule)
@@ -31,12 +35,17 @@ func (client *Client) watchLbRules() {
 
 func (client *Client) watchMwRules() {
 	rch := client.v3.Watch(client.ctx, "/eve/mwrules", clientv3.WithPrefix())
+	metrics.EtcdWatchUp.WithLabelValues("/eve/mwrules").Set(1)
+	defer metrics.EtcdWatchUp.WithLabelValues("/eve/mwrules").Set(0)
 	for wresp := range rch {
+		if !client.watchHealthy("/eve/mwrules", wresp) {
+			continue
+		}
 		for _, ev := range wresp.Events {
 			if ev.Type == mvccpb.PUT {
 				rule, err := client.parseMwRule(ev.Kv)
 				if err != nil {
-					log.Print(err)
+					client.logger.Warn("skipping malformed key", "key", string(ev.Kv.Key), "error", err)
 					continue
 				}
 				client.feedUpsertMwRuleToChannel(rule)
@@ -50,19 +59,24 @@ func (client *Client) watchMwRules() {
 
 func (client *Client) watchHosts() {
 	rch := client.v3.Watch(client.ctx, "/eve/loadbalancer", clientv3.WithPrefix())
+	metrics.EtcdWatchUp.WithLabelValues("/eve/loadbalancer").Set(1)
+	defer metrics.EtcdWatchUp.WithLabelValues("/eve/loadbalancer").Set(0)
 	for wresp := range rch {
+		if !client.watchHealthy("/eve/loadbalancer", wresp) {
+			continue
+		}
 		for _, ev := range wresp.Events {
 			if ev.Type == mvccpb.PUT {
 				cfg, err := client.parseHostConfig(ev.Kv)
 				if err != nil {
-					log.Print(err)
+					client.logger.Warn("skipping malformed key", "key", string(ev.Kv.Key), "error", err)
 					continue
 				}
 				client.feedUpsertHostToChannel(cfg)
 			} else {
 				cfg, err := client.parseHostConfig(ev.Kv)
 				if err != nil {
-					log.Print(err)
+					client.logger.Warn("skipping malformed key", "key", string(ev.Kv.Key), "error", err)
 					continue
 				}
 				client.feedDeleteHostToChannel(cfg)
@@ -73,12 +87,17 @@ func (client *Client) watchHosts() {
 
 func (client *Client) watchCerts() {
 	rch := client.v3.Watch(client.ctx, "/eve/certs", clientv3.WithPrefix())
+	metrics.EtcdWatchUp.WithLabelValues("/eve/certs").Set(1)
+	defer metrics.EtcdWatchUp.WithLabelValues("/eve/certs").Set(0)
 	for wresp := range rch {
+		if !client.watchHealthy("/eve/certs", wresp) {
+			continue
+		}
 		for _, ev := range wresp.Events {
 			if ev.Type == mvccpb.PUT {
 				cfg, err := client.parseCertConfig(ev.Kv)
 				if err != nil {
-					log.Print(err)
+					client.logger.Warn("skipping malformed key", "key", string(ev.Kv.Key), "error", err)
 					continue
 				}
 				client.feedUpsertCertToChannel(cfg)
@@ -90,3 +109,57 @@ func (client *Client) watchCerts() {
 		}
 	}
 }
+
+func (client *Client) watchLbConfigs() {
+	rch := client.v3.Watch(client.ctx, "/eve/lbconfigs", clientv3.WithPrefix())
+	metrics.EtcdWatchUp.WithLabelValues("/eve/lbconfigs").Set(1)
+	defer metrics.EtcdWatchUp.WithLabelValues("/eve/lbconfigs").Set(0)
+	for wresp := range rch {
+		if !client.watchHealthy("/eve/lbconfigs", wresp) {
+			continue
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.PUT {
+				cfg, err := client.parseLbConfig(ev.Kv)
+				if err != nil {
+					client.logger.Warn("skipping malformed key", "key", string(ev.Kv.Key), "error", err)
+					continue
+				}
+				client.feedUpsertLbConfigToChannel(cfg)
+			} else {
+				cfg := &config.LoadbalancerConfig{}
+				cfg.ID = string(ev.Kv.Key[len("/eve/lbconfigs/"):])
+				client.feedDeleteLbConfigToChannel(cfg)
+			}
+		}
+	}
+}
+
+func (client *Client) watchLogLevel() {
+	rch := client.v3.Watch(client.ctx, "/eve/loglevel")
+	metrics.EtcdWatchUp.WithLabelValues("/eve/loglevel").Set(1)
+	defer metrics.EtcdWatchUp.WithLabelValues("/eve/loglevel").Set(0)
+	for wresp := range rch {
+		if !client.watchHealthy("/eve/loglevel", wresp) {
+			continue
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.PUT {
+				client.feedSetLogLevelToChannel(string(ev.Kv.Value))
+			} else {
+				client.feedSetLogLevelToChannel(client.defaultLogLevel)
+			}
+		}
+	}
+}
+
+// watchHealthy updates the watch status metric and reports whether the response carries events
+func (client *Client) watchHealthy(prefix string, wresp clientv3.WatchResponse) bool {
+	if err := wresp.Err(); err != nil {
+		metrics.EtcdWatchUp.WithLabelValues(prefix).Set(0)
+		client.logger.Error("watch failed", "prefix", prefix, "error", err)
+		return false
+	}
+	metrics.EtcdWatchUp.WithLabelValues(prefix).Set(1)
+	return true
+}
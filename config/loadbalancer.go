@@ -0,0 +1,224 @@
+package config
+
+import "fmt"
+
+// LoadbalancerConfig holds the settings of a loadbalancer.
+// Loadbalancers without config use the defaults.
+type LoadbalancerConfig struct {
+	ID               string
+	OutlierDetection *OutlierDetectionConfig `json:",omitempty"`
+	CircuitBreaker   *CircuitBreakerConfig   `json:",omitempty"`
+	Retry            *RetryConfig            `json:",omitempty"`
+	TLS              *UpstreamTLSConfig      `json:",omitempty"`
+	Transport        *TransportConfig        `json:",omitempty"`
+	Buffering        *BufferingConfig        `json:",omitempty"`
+	SlowStart        *SlowStartConfig        `json:",omitempty"`
+	Failover         *FailoverConfig         `json:",omitempty"`
+	Discovery        *DiscoveryConfig        `json:",omitempty"`
+	FastCGI          *FastCGIConfig          `json:",omitempty"`
+}
+
+// OutlierDetectionConfig configures the passive health checking of the hosts of a loadbalancer.
+// Hosts whose error rate or mean latency exceed the limits within an interval are ejected
+// from the pool. The ejection time doubles with every consecutive ejection.
+type OutlierDetectionConfig struct {
+	// Interval is the evaluation interval (default 10s)
+	Interval Duration
+	// MinRequests is the number of requests a host must have served in an interval to be evaluated (default 5)
+	MinRequests int
+	// MaxErrorRate is the tolerated ratio of 5xx responses and connection errors (default 0.5)
+	MaxErrorRate float64
+	// MaxLatency is the tolerated mean latency (default: unlimited)
+	MaxLatency Duration
+	// BaseEjectionTime is the time a host is ejected for the first time (default 30s)
+	BaseEjectionTime Duration
+	// MaxEjectionTime caps the ejection backoff (default 5m)
+	MaxEjectionTime Duration
+	// MaxEjectionPercent caps the percentage of ejected hosts of the pool (default 50)
+	MaxEjectionPercent int
+}
+
+// CircuitBreakerConfig configures the response of a loadbalancer whose whole pool is unhealthy.
+// While the breaker is open, only one probe request per ProbeInterval is forwarded.
+type CircuitBreakerConfig struct {
+	// Status is the status code of the fallback response (default 503)
+	Status int
+	// Body is the body of the fallback response
+	Body string
+	// ContentType is the content type of the fallback response (default text/plain)
+	ContentType string
+	// ProbeInterval is the time between probe requests (default 5s)
+	ProbeInterval Duration
+}
+
+// RetryConfig configures the retries of failed requests. Retries always go to a different host.
+// If no retry condition is set, connect errors are retried.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of attempts including the first one (default 2)
+	MaxAttempts int
+	// OnConnectError retries requests whose upstream connection failed
+	OnConnectError bool
+	// OnTimeout retries requests which timed out
+	OnTimeout bool
+	// OnStatus retries requests which got one of these status codes
+	OnStatus []int
+	// Force retries non-idempotent requests (POST, PATCH) as well
+	Force bool
+	// MaxBodyBytes is the maximum size of a request body which is buffered for retries (default 64KiB).
+	// Requests with bigger bodies are not retried.
+	MaxBodyBytes int64
+	// BudgetPercent limits the retries to this percentage of the requests of the last 10 seconds (default 20)
+	BudgetPercent float64
+	// BudgetMinPerSecond allows this many retries per second regardless of the budget percentage (default 3)
+	BudgetMinPerSecond int
+}
+
+// TransportConfig tunes the connections to the hosts of a loadbalancer.
+// Zero values keep the defaults of Go's http.DefaultTransport.
+type TransportConfig struct {
+	// DialTimeout limits the time to establish a connection (default 30s)
+	DialTimeout Duration
+	// KeepAlive is the TCP keepalive interval (default 30s)
+	KeepAlive Duration
+	// TLSHandshakeTimeout limits the time of the TLS handshake (default 10s)
+	TLSHandshakeTimeout Duration
+	// ResponseHeaderTimeout limits the time to wait for the response headers (default: unlimited)
+	ResponseHeaderTimeout Duration
+	// IdleConnTimeout closes idle connections after this time (default 90s)
+	IdleConnTimeout Duration
+	// MaxIdleConnsPerHost is the size of the keepalive pool per host (default 2)
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections per host (default: unlimited)
+	MaxConnsPerHost int
+}
+
+// BufferingConfig enables the buffering of requests and responses, so that slow clients
+// don't hold upstream connections. Bodies exceeding the Mem limits are buffered on disk.
+type BufferingConfig struct {
+	// MaxRequestBodyBytes rejects bigger requests with 413 (default: unlimited)
+	MaxRequestBodyBytes int64
+	// MemRequestBodyBytes is the part of a request buffered in memory (default 1MiB)
+	MemRequestBodyBytes int64
+	// MaxResponseBodyBytes answers bigger responses with 500 (default: unlimited)
+	MaxResponseBodyBytes int64
+	// MemResponseBodyBytes is the part of a response buffered in memory (default 1MiB)
+	MemResponseBodyBytes int64
+}
+
+// SlowStartConfig configures the ramp-up of newly added hosts.
+// Within the window, the weight of a new host grows from MinWeightPercent to its full weight.
+type SlowStartConfig struct {
+	// Window is the duration of the ramp-up (default 30s)
+	Window Duration
+	// Mode is the shape of the ramp-up: linear (default) or exponential
+	Mode string
+	// MinWeightPercent is the initial weight in percent of the full weight (default 10)
+	MinWeightPercent float64
+}
+
+// FailoverConfig configures the failover between the priority tiers of the hosts.
+// Traffic goes to the tier with the lowest priority number whose healthy hosts reach MinHealthyPercent.
+// If no tier reaches it, the first tier with any healthy host is used.
+type FailoverConfig struct {
+	// MinHealthyPercent is the percentage of healthy hosts a tier needs to get traffic (default 70)
+	MinHealthyPercent int
+}
+
+// DiscoveryConfig makes a loadbalancer discover its hosts via DNS.
+// The name is re-resolved when the TTL of the records expires, but at least every Interval.
+// Discovered hosts are added to the hosts registered with eve-ctl.
+type DiscoveryConfig struct {
+	// Name is the DNS name to resolve
+	Name string
+	// Type is the record type: A (A and AAAA records, default) or SRV (ports and priority tiers from the records)
+	Type string
+	// Scheme is the scheme of the host URLs (default http)
+	Scheme string
+	// Port is the port of the hosts for A records (default 80 for http, 443 for https)
+	Port int
+	// Server is the DNS server to ask (default: the first nameserver of /etc/resolv.conf)
+	Server string
+	// Interval is the maximum time between two resolutions (default 30s)
+	Interval Duration
+}
+
+// FastCGIConfig makes a loadbalancer talk FastCGI instead of HTTP to its hosts.
+// Host URLs are tcp://host:port or unix:///path/to/socket.
+type FastCGIConfig struct {
+	// Root is the document root on the FastCGI hosts
+	Root string
+	// Index is the script which serves directory requests (default index.php)
+	Index string
+	// SplitPath splits the request path into SCRIPT_NAME and PATH_INFO after this extension, which must end a path segment (default .php)
+	SplitPath string
+	// Params are additional FastCGI parameters
+	Params map[string]string `json:",omitempty"`
+}
+
+// UpstreamTLSConfig configures the TLS connections to https:// hosts.
+// Like CertConfig, the client certificate and key are stored sealed with the eve password.
+type UpstreamTLSConfig struct {
+	// CAPem is the PEM encoded CA bundle to verify the hosts with (default: system roots)
+	CAPem string `json:",omitempty"`
+	// CertPem is the PEM encoded client certificate
+	CertPem string `json:",omitempty"`
+	// KeyPem is the PEM encoded client key
+	KeyPem string `json:",omitempty"`
+	// ServerName overrides the server name used for SNI and verification
+	ServerName string `json:",omitempty"`
+	// InsecureSkipVerify disables the verification of the host certificates
+	InsecureSkipVerify bool `json:",omitempty"`
+}
+
+// Encrypt seals the client certificate and key with a password
+func (cfg *UpstreamTLSConfig) Encrypt(password string) error {
+	if cfg.CertPem != "" {
+		certPem, err := encrypt(cfg.CertPem, password)
+		if err != nil {
+			return fmt.Errorf("client cert: %v", err)
+		}
+		cfg.CertPem = certPem
+	}
+	if cfg.KeyPem != "" {
+		keyPem, err := encrypt(cfg.KeyPem, password)
+		if err != nil {
+			return fmt.Errorf("client key: %v", err)
+		}
+		cfg.KeyPem = keyPem
+	}
+	return nil
+}
+
+// Decrypt decrypts a sealed client certificate and key
+func (cfg *UpstreamTLSConfig) Decrypt(password string) error {
+	if cfg.CertPem != "" {
+		certPem, err := decrypt(cfg.CertPem, password)
+		if err != nil {
+			return fmt.Errorf("client cert: %v", err)
+		}
+		cfg.CertPem = certPem
+	}
+	if cfg.KeyPem != "" {
+		keyPem, err := decrypt(cfg.KeyPem, password)
+		if err != nil {
+			return fmt.Errorf("client key: %v", err)
+		}
+		cfg.KeyPem = keyPem
+	}
+	return nil
+}
+
+// CheckSealed checks that the client certificate and key look sealed, so they can be rejected before they are applied
+func (cfg *UpstreamTLSConfig) CheckSealed() error {
+	if cfg.CertPem != "" {
+		if _, err := checkSealed(cfg.CertPem); err != nil {
+			return fmt.Errorf("client cert: %v", err)
+		}
+	}
+	if cfg.KeyPem != "" {
+		if _, err := checkSealed(cfg.KeyPem); err != nil {
+			return fmt.Errorf("client key: %v", err)
+		}
+	}
+	return nil
+}
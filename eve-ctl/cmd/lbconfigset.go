@@ -0,0 +1,65 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+// lbconfigsetCmd represents the lbconfigset command
+var lbconfigsetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "set the settings of a loadbalancer",
+	Long: `set the settings of a loadbalancer.
+The json is merged into the stored settings, fields it doesn't mention (i.e. the TLS settings) are kept.
+
+Example:
+  eve-ctl loadbalancer config set --id echo-lb --json '{
+    "OutlierDetection": {"MaxErrorRate": 0.3, "BaseEjectionTime": "30s"},
+    "CircuitBreaker": {"Status": 503, "Body": "try again later"}
+  }'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("id")
+		settings, _ := cmd.Flags().GetString("json")
+		if id == "" || settings == "" {
+			log.Fatal("specify --id and --json")
+		}
+		cfg, err := getLbConfig(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(settings), cfg); err != nil {
+			log.Fatal(err)
+		}
+		cfg.ID = id
+		if err := client.PutLbConfig(cfg, true); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	lbconfigCmd.AddCommand(lbconfigsetCmd)
+	lbconfigsetCmd.Flags().StringP("json", "j", "", "json object describing the loadbalancer settings")
+}
@@ -0,0 +1,117 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/trusch/eve/config"
+)
+
+// lbconfigtlsCmd represents the lbconfigtls command
+var lbconfigtlsCmd = &cobra.Command{
+	Use:   "tls",
+	Short: "set the upstream TLS settings of a loadbalancer",
+	Long: `set the upstream TLS settings of a loadbalancer.
+The client certificate and key are encrypted with the password, which must match the --password of eve.
+
+Example:
+  eve-ctl loadbalancer config tls --id echo-lb --ca /etc/certs/ca.crt \
+    --cert /etc/certs/client.crt --key /etc/certs/client.key --password super-secure-password`,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("id")
+		caPath, _ := cmd.Flags().GetString("ca")
+		certPath, _ := cmd.Flags().GetString("cert")
+		keyPath, _ := cmd.Flags().GetString("key")
+		password, _ := cmd.Flags().GetString("password")
+		serverName, _ := cmd.Flags().GetString("server-name")
+		insecure, _ := cmd.Flags().GetBool("insecure-skip-verify")
+		if id == "" {
+			log.Fatal("specify --id")
+		}
+		if (certPath == "") != (keyPath == "") {
+			log.Fatal("specify both --cert and --key")
+		}
+		if certPath != "" && password == "" {
+			log.Fatal("specify --password to encrypt the client certificate")
+		}
+		tlsConfig := &config.UpstreamTLSConfig{
+			ServerName:         serverName,
+			InsecureSkipVerify: insecure,
+		}
+		if caPath != "" {
+			bs, err := ioutil.ReadFile(caPath)
+			if err != nil {
+				log.Fatal("can not read CA file")
+			}
+			tlsConfig.CAPem = string(bs)
+		}
+		if certPath != "" {
+			certBs, err := ioutil.ReadFile(certPath)
+			if err != nil {
+				log.Fatal("can not read certificate file")
+			}
+			keyBs, err := ioutil.ReadFile(keyPath)
+			if err != nil {
+				log.Fatal("can not read key file")
+			}
+			tlsConfig.CertPem = string(certBs)
+			tlsConfig.KeyPem = string(keyBs)
+			if err = tlsConfig.Encrypt(password); err != nil {
+				log.Fatal(err)
+			}
+		}
+		cfg, err := getLbConfig(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.TLS = tlsConfig
+		if err := client.PutLbConfig(cfg, true); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// getLbConfig returns the stored settings of a loadbalancer or empty ones
+func getLbConfig(id string) (*config.LoadbalancerConfig, error) {
+	cfgs, err := client.GetLoadbalancerConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range cfgs {
+		if cfg.ID == id {
+			return cfg, nil
+		}
+	}
+	return &config.LoadbalancerConfig{ID: id}, nil
+}
+
+func init() {
+	lbconfigCmd.AddCommand(lbconfigtlsCmd)
+	lbconfigtlsCmd.Flags().String("ca", "", "CA bundle path")
+	lbconfigtlsCmd.Flags().String("cert", "", "client certificate path")
+	lbconfigtlsCmd.Flags().String("key", "", "client key path")
+	lbconfigtlsCmd.Flags().String("password", "", "password to encrypt data")
+	lbconfigtlsCmd.Flags().String("server-name", "", "server name for SNI and verification")
+	lbconfigtlsCmd.Flags().Bool("insecure-skip-verify", false, "don't verify the host certificates")
+}
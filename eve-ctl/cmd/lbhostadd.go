@@ -35,10 +35,11 @@ var lbhostaddCmd = &cobra.Command{
 		lb, _ := cmd.Flags().GetString("loadbalancer")
 		id, _ := cmd.Flags().GetString("id")
 		url, _ := cmd.Flags().GetString("url")
+		priority, _ := cmd.Flags().GetInt("priority")
 		if lb == "" || id == "" || url == "" {
 			log.Fatal("specify --loadbalancer, --id and --url")
 		}
-		if err := client.PutHostConfig(&config.HostConfig{ID: id, Loadbalancer: lb, URL: url}, true); err != nil {
+		if err := client.PutHostConfig(&config.HostConfig{ID: id, Loadbalancer: lb, URL: url, Priority: priority}, true); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -47,5 +48,6 @@ var lbhostaddCmd = &cobra.Command{
 func init() {
 	hostCmd.AddCommand(lbhostaddCmd)
 	lbhostaddCmd.Flags().String("url","", "target URL")
+	lbhostaddCmd.Flags().Int("priority", 0, "priority tier, 0 is the primary tier")
 
 }
@@ -23,6 +23,7 @@ package cmd
 import (
 	"os"
 	"log"
+	"strconv"
 
 	"github.com/spf13/cobra"
 	"github.com/olekukonko/tablewriter"
@@ -39,9 +40,9 @@ var lbhostlistCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"ID", "Loadbalancer", "URL"})
+		table.SetHeader([]string{"ID", "Loadbalancer", "URL", "Priority"})
 		for _,host := range hosts {
-			table.Append([]string{host.ID, host.Loadbalancer, host.URL})
+			table.Append([]string{host.ID, host.Loadbalancer, host.URL, strconv.Itoa(host.Priority)})
 		}
 		table.Render()
 	},
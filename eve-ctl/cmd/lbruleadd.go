@@ -21,7 +21,10 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"github.com/trusch/eve/loadbalancer/rule"
 	"github.com/spf13/cobra"
 )
@@ -30,15 +33,51 @@ import (
 var lbruleaddCmd = &cobra.Command{
 	Use:   "add",
 	Short: "add a loadbalancer rule",
-	Long: `add a loadbalancer rule`,
+	Long: `add a loadbalancer rule
+
+Example of a canary release with 5% of the traffic going to app-v2:
+  eve-ctl loadbalancer rule add --id app --route 'Host("app.example.tld")' \
+    --split app-v1=95,app-v2=5 --sticky app-variant --override-header X-Variant`,
 	Run: func(cmd *cobra.Command, args []string) {
 		id, _ := cmd.Flags().GetString("id")
 		target, _ := cmd.Flags().GetString("target")
 		route, _ := cmd.Flags().GetString("route")
-		if target == "" || id == "" || route == "" {
-			log.Fatal("specify --target, --id and --route")
+		split, _ := cmd.Flags().GetString("split")
+		if (target == "" && split == "") || id == "" || route == "" {
+			log.Fatal("specify --target or --split, --id and --route")
+		}
+		lbRule := &rule.Rule{ID: id, Target: target, Route: route}
+		if split != "" {
+			targets, err := parseSplit(split)
+			if err != nil {
+				log.Fatal(err)
+			}
+			lbRule.Split = targets
+		}
+		lbRule.Sticky, _ = cmd.Flags().GetString("sticky")
+		overrideHeader, _ := cmd.Flags().GetString("override-header")
+		overrideCookie, _ := cmd.Flags().GetString("override-cookie")
+		if overrideHeader != "" || overrideCookie != "" {
+			lbRule.Override = &rule.Override{Header: overrideHeader, Cookie: overrideCookie}
 		}
-		if err := client.PutLbRule(&rule.Rule{ID: id, Target: target, Route: route}, true); err != nil {
+		if mirror, _ := cmd.Flags().GetString("mirror"); mirror != "" {
+			maxBody, _ := cmd.Flags().GetInt64("mirror-max-body")
+			lbRule.Mirror = &rule.Mirror{Target: mirror, MaxBodyBytes: maxBody}
+			if cmd.Flags().Changed("mirror-percent") {
+				percent, _ := cmd.Flags().GetFloat64("mirror-percent")
+				lbRule.Mirror.Percent = &percent
+			}
+		}
+		rewrite, err := parseRewrite(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		lbRule.Rewrite = rewrite
+		if cmd.Flags().Changed("sample-rate") {
+			sampleRate, _ := cmd.Flags().GetFloat64("sample-rate")
+			lbRule.SampleRate = &sampleRate
+		}
+		if err := client.PutLbRule(lbRule, true); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -48,4 +87,92 @@ func init() {
 	ruleCmd.AddCommand(lbruleaddCmd)
 	lbruleaddCmd.Flags().StringP("target", "t", "", "target loadbalancer")
 	lbruleaddCmd.Flags().StringP("route", "r", "", "routing rule (i.e. Host(\"foo.example.tld\"))")
+	lbruleaddCmd.Flags().String("split", "", "weighted target loadbalancers (i.e. app-v1=95,app-v2=5)")
+	lbruleaddCmd.Flags().String("sticky", "", "name of a cookie which keeps clients on their split target")
+	lbruleaddCmd.Flags().String("override-header", "", "header whose value forces a split target")
+	lbruleaddCmd.Flags().String("override-cookie", "", "cookie whose value forces a split target")
+	lbruleaddCmd.Flags().String("mirror", "", "shadow loadbalancer which gets a copy of the requests")
+	lbruleaddCmd.Flags().Float64("mirror-percent", 100, "percentage of mirrored requests")
+	lbruleaddCmd.Flags().Int64("mirror-max-body", 64*1024, "maximum body size of mirrored requests")
+	lbruleaddCmd.Flags().String("strip-prefix", "", "path prefix to strip")
+	lbruleaddCmd.Flags().String("add-prefix", "", "path prefix to add")
+	lbruleaddCmd.Flags().String("rewrite-regex", "", "regex to replace in the path")
+	lbruleaddCmd.Flags().String("rewrite-replacement", "", "replacement of --rewrite-regex, can contain $1 style references")
+	lbruleaddCmd.Flags().StringSlice("set-query", nil, "query parameters to set (key=value)")
+	lbruleaddCmd.Flags().StringSlice("add-query", nil, "query parameters to add (key=value)")
+	lbruleaddCmd.Flags().StringSlice("remove-query", nil, "query parameters to remove")
+	lbruleaddCmd.Flags().String("host-header", "", "Host header sent upstream")
+	lbruleaddCmd.Flags().Bool("pass-host", false, "pass the Host header of the client upstream")
+	lbruleaddCmd.Flags().Float64("sample-rate", 1, "trace sample rate of matching requests, overrides eve's --tracing-sample-rate")
+}
+
+// parseSplit parses a list of weighted targets like app-v1=95,app-v2=5
+func parseSplit(split string) ([]*rule.SplitTarget, error) {
+	var targets []*rule.SplitTarget
+	for _, part := range strings.Split(split, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("malformed split target %q, expected target=weight", part)
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("malformed weight of split target %q", part)
+		}
+		targets = append(targets, &rule.SplitTarget{Target: kv[0], Weight: weight})
+	}
+	return targets, nil
+}
+
+// formatTargets returns the target or the weighted split of a rule
+func formatTargets(lbRule *rule.Rule) string {
+	if len(lbRule.Split) == 0 {
+		return lbRule.Target
+	}
+	parts := make([]string, len(lbRule.Split))
+	for i, split := range lbRule.Split {
+		parts[i] = fmt.Sprintf("%v=%v", split.Target, split.Weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseRewrite returns the rewrite configured by the flags or nil
+func parseRewrite(cmd *cobra.Command) (*rule.Rewrite, error) {
+	rw := &rule.Rewrite{}
+	rw.StripPrefix, _ = cmd.Flags().GetString("strip-prefix")
+	rw.AddPrefix, _ = cmd.Flags().GetString("add-prefix")
+	rw.Regex, _ = cmd.Flags().GetString("rewrite-regex")
+	rw.Replacement, _ = cmd.Flags().GetString("rewrite-replacement")
+	rw.RemoveQuery, _ = cmd.Flags().GetStringSlice("remove-query")
+	rw.Host, _ = cmd.Flags().GetString("host-header")
+	rw.PassHost, _ = cmd.Flags().GetBool("pass-host")
+	var err error
+	setQuery, _ := cmd.Flags().GetStringSlice("set-query")
+	if rw.SetQuery, err = parseKeyValues(setQuery); err != nil {
+		return nil, err
+	}
+	addQuery, _ := cmd.Flags().GetStringSlice("add-query")
+	if rw.AddQuery, err = parseKeyValues(addQuery); err != nil {
+		return nil, err
+	}
+	if rw.StripPrefix == "" && rw.AddPrefix == "" && rw.Regex == "" && len(rw.SetQuery) == 0 &&
+		len(rw.AddQuery) == 0 && len(rw.RemoveQuery) == 0 && rw.Host == "" && !rw.PassHost {
+		return nil, nil
+	}
+	return rw, nil
+}
+
+// parseKeyValues parses a list of key=value pairs
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("malformed key value pair %q, expected key=value", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
 }
@@ -40,7 +40,7 @@ var lbrulelistCmd = &cobra.Command{
 		table := tablewriter.NewWriter(os.Stdout)
 		table.SetHeader([]string{"ID", "Route", "Target"})
 		for _,rule := range rules {
-			table.Append([]string{rule.ID, rule.Route, rule.Target})
+			table.Append([]string{rule.ID, rule.Route, formatTargets(rule)})
 		}
 		table.Render()
 	},
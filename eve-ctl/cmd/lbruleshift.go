@@ -0,0 +1,169 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trusch/eve/loadbalancer/rule"
+)
+
+// lbruleshiftCmd represents the lbruleshift command
+var lbruleshiftCmd = &cobra.Command{
+	Use:   "shift",
+	Short: "gradually shift the traffic of a split rule to one target",
+	Long: `gradually shift the traffic of a split rule to one target.
+The weights are normalized to percent. Every interval the target gets --step percent more,
+the other targets lose weight in proportion to their current weights.
+
+Example:
+  eve-ctl loadbalancer rule shift --id app --target app-v2 --step 10 --interval 5m`,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("id")
+		target, _ := cmd.Flags().GetString("target")
+		step, _ := cmd.Flags().GetInt("step")
+		goal, _ := cmd.Flags().GetInt("weight")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if id == "" || target == "" {
+			log.Fatal("specify --id and --target")
+		}
+		if step <= 0 || goal < 0 || goal > 100 {
+			log.Fatal("--step must be positive and --weight between 0 and 100")
+		}
+		for {
+			lbRule, err := getLbRule(id)
+			if err != nil {
+				log.Fatal(err)
+			}
+			current := normalizeSplit(lbRule.Split)
+			weight, ok := current[target]
+			if !ok {
+				log.Fatalf("%v is not a split target of rule %v", target, id)
+			}
+			next := weight + step
+			if weight > goal {
+				next = weight - step
+				if next < goal {
+					next = goal
+				}
+			} else if next > goal {
+				next = goal
+			}
+			shiftSplit(lbRule.Split, target, next)
+			if err := client.PutLbRule(lbRule, true); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("%v: %v", id, formatTargets(lbRule))
+			if next == goal {
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+// getLbRule returns a stored loadbalancer rule
+func getLbRule(id string) (*rule.Rule, error) {
+	rules, err := client.GetLoadbalancerRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, lbRule := range rules {
+		if lbRule.ID == id {
+			return lbRule, nil
+		}
+	}
+	return nil, fmt.Errorf("rule %v not found", id)
+}
+
+// normalizeSplit returns the weights of the split targets in percent.
+// They add up to 100, the rounding leftovers go to the targets with the largest remainders.
+func normalizeSplit(split []*rule.SplitTarget) map[string]int {
+	total := 0
+	for _, s := range split {
+		total += s.Weight
+	}
+	weights := make(map[string]int)
+	if total == 0 {
+		for _, s := range split {
+			weights[s.Target] = 0
+		}
+		return weights
+	}
+	remainders := make([]int, len(split))
+	assigned := 0
+	for i, s := range split {
+		weights[s.Target] = s.Weight * 100 / total
+		remainders[i] = s.Weight * 100 % total
+		assigned += weights[s.Target]
+	}
+	for ; assigned < 100; assigned++ {
+		largest := 0
+		for i := range split {
+			if remainders[i] > remainders[largest] {
+				largest = i
+			}
+		}
+		weights[split[largest].Target]++
+		remainders[largest] = -1
+	}
+	return weights
+}
+
+// shiftSplit sets the weight of target in percent and distributes the rest
+// among the other targets in proportion to their current weights
+func shiftSplit(split []*rule.SplitTarget, target string, weight int) {
+	othersTotal := 0
+	var others []*rule.SplitTarget
+	for _, s := range split {
+		if s.Target == target {
+			s.Weight = weight
+			continue
+		}
+		othersTotal += s.Weight
+		others = append(others, s)
+	}
+	if len(others) == 0 {
+		return
+	}
+	rest, assigned := 100-weight, 0
+	for _, s := range others {
+		if othersTotal == 0 {
+			s.Weight = rest / len(others)
+		} else {
+			s.Weight = s.Weight * rest / othersTotal
+		}
+		assigned += s.Weight
+	}
+	// rounding leftovers go to the first other target
+	others[0].Weight += rest - assigned
+}
+
+func init() {
+	ruleCmd.AddCommand(lbruleshiftCmd)
+	lbruleshiftCmd.Flags().String("target", "", "split target to shift the traffic to")
+	lbruleshiftCmd.Flags().Int("step", 10, "percent to shift per interval")
+	lbruleshiftCmd.Flags().Int("weight", 100, "final weight of the target in percent")
+	lbruleshiftCmd.Flags().Duration("interval", time.Minute, "time between two steps")
+}
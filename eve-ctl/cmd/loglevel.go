@@ -0,0 +1,79 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/trusch/eve/logging"
+)
+
+// loglevelCmd represents the loglevel command
+var loglevelCmd = &cobra.Command{
+	Use:   "loglevel",
+	Short: "get/set the log level of eve",
+	Long:  `get/set the log level of all eve instances at runtime`,
+}
+
+// loglevelsetCmd represents the loglevel set command
+var loglevelsetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "set the log level",
+	Long:  `set the log level (debug, info, warn or error)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		level, _ := cmd.Flags().GetString("level")
+		if level == "" {
+			log.Fatal("specify --level")
+		}
+		if err := logging.SetLevel(level); err != nil {
+			log.Fatal(err)
+		}
+		if err := client.PutLogLevel(level); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// loglevelgetCmd represents the loglevel get command
+var loglevelgetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "print the log level",
+	Long:  `print the log level`,
+	Run: func(cmd *cobra.Command, args []string) {
+		level, err := client.GetLogLevel()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if level == "" {
+			level = "info"
+		}
+		fmt.Println(level)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(loglevelCmd)
+	loglevelCmd.AddCommand(loglevelsetCmd)
+	loglevelCmd.AddCommand(loglevelgetCmd)
+	loglevelsetCmd.Flags().StringP("level", "l", "", "log level: debug, info, warn or error")
+}
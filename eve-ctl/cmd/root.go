@@ -23,6 +23,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -78,7 +79,7 @@ func initConfig() {
 
 func initClient() {
 	addr, _ := RootCmd.Flags().GetString("etcd")
-	cli, err := etcd.NewClient(addr)
+	cli, err := etcd.NewClient(addr, "info", slog.Default())
 	if err != nil {
 		log.Fatal(err)
 	}
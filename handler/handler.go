@@ -1,33 +1,93 @@
 package handler
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/trusch/eve/accesslog"
 	loadbalancer "github.com/trusch/eve/loadbalancer/manager"
+	"github.com/trusch/eve/metrics"
 	middleware "github.com/trusch/eve/middleware/manager"
+	"github.com/trusch/eve/requestinfo"
+	"github.com/trusch/eve/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Handler is the global http request handler
 type Handler struct {
 	LBManager *loadbalancer.Manager
 	MWManager *middleware.Manager
+	// AccessLog is the global access log, nil if disabled
+	AccessLog *accesslog.Logger
+	logger    *slog.Logger
 }
 
 func (handler *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	chain, err := handler.MWManager.BuildChain(req, handler.LBManager)
+	metrics.RequestsInFlight.Inc()
+	defer metrics.RequestsInFlight.Dec()
+	info := &requestinfo.Info{Start: time.Now()}
+	ctx := requestinfo.NewContext(tracing.Extract(req), info)
+	req = req.WithContext(ctx)
+	rw := requestinfo.NewResponseWriter(w)
+
+	// the loadbalancer rule is matched before the request span is started,
+	// because it decides about the sample rate
+	rule, routeErr := handler.LBManager.Route(req)
+	if rule != nil && rule.SampleRate != nil {
+		ctx = tracing.WithSampleRate(ctx, *rule.SampleRate)
+	}
+	ctx, span := tracing.Start(ctx, "eve.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(info.Start),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.Host),
+		),
+	)
+	req = req.WithContext(ctx)
+	defer func() {
+		info.Status = rw.StatusCode()
+		info.Bytes = rw.Bytes
+		metrics.ObserveRequest(info)
+		if handler.AccessLog != nil {
+			if err := handler.AccessLog.Log(req, info); err != nil {
+				metrics.AccessLogErrors.WithLabelValues("").Inc()
+				handler.logger.Warn("failed to write access log", "error", err)
+			}
+		}
+		span.SetAttributes(
+			attribute.String("eve.rule", info.RuleID),
+			attribute.String("eve.loadbalancer", info.Loadbalancer),
+			attribute.Int("http.response.status_code", info.Status),
+		)
+		if info.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(info.Status))
+		}
+		span.End()
+	}()
+
+	_, routeSpan := tracing.Start(ctx, "route", trace.WithTimestamp(info.Start))
+	if routeErr != nil {
+		routeSpan.RecordError(routeErr)
+	}
+	chain, release, err := handler.MWManager.BuildChain(req, handler.LBManager)
+	routeSpan.End()
 	if err != nil {
-		log.Printf("Error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		handler.logger.Error("failed to build middleware chain", "host", req.Host, "path", req.URL.Path, "error", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write([]byte(err.Error()))
 		return
 	}
-	chain.ServeHTTP(w, req)
+	defer release()
+	chain.ServeHTTP(rw, req)
 }
 
 // New returns a new handler
-func New() *Handler {
-	lbManager := loadbalancer.New()
-	mwManager := middleware.New()
-	return &Handler{lbManager, mwManager}
+func New(logger *slog.Logger) *Handler {
+	lbManager := loadbalancer.New(logger.With("component", "loadbalancer"))
+	mwManager := middleware.New(logger.With("component", "middleware"))
+	return &Handler{LBManager: lbManager, MWManager: mwManager, logger: logger}
 }
@@ -2,69 +2,135 @@ package manager
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/trusch/eve/config"
 	"github.com/trusch/eve/loadbalancer/rule"
-	"github.com/vulcand/oxy/forward"
-	"github.com/vulcand/oxy/roundrobin"
+	"github.com/trusch/eve/metrics"
+	"github.com/trusch/eve/requestinfo"
 )
 
 // Manager manages available loadbalancers
 type Manager struct {
-	loadbalancers map[string]*roundrobin.Rebalancer
+	mutex         sync.RWMutex
+	loadbalancers map[string]*loadbalancer
+	configs       map[string]*config.LoadbalancerConfig
 	ruleset       *rule.Set
 	hosts         map[string]*config.HostConfig
+	mirrors       chan struct{}
+	logger        *slog.Logger
 }
 
-// NewLoadbalancer returns a new Loadbalancer. In facts its a chain: rebalancer -> roundrobin -> forward
-func NewLoadbalancer() *roundrobin.Rebalancer {
-	fwd, _ := forward.New()
-	lb, _ := roundrobin.New(fwd)
-	rb, _ := roundrobin.NewRebalancer(lb)
-	return rb
+// LoadbalancerStatus describes a loadbalancer and its hosts
+type LoadbalancerStatus struct {
+	ID          string
+	Config      *config.LoadbalancerConfig
+	CircuitOpen bool
+	ActiveTier  int
+	Hosts       []*HostStatus
+}
+
+// HostStatus describes a host registered at a loadbalancer
+type HostStatus struct {
+	ID           string
+	URL          string
+	Priority     int
+	Healthy      bool
+	Active       bool
+	Weight       int
+	EjectedUntil time.Time
 }
 
 // New returns a new LB Manager
-func New() *Manager {
+func New(logger *slog.Logger) *Manager {
 	return &Manager{
-		loadbalancers: make(map[string]*roundrobin.Rebalancer),
+		loadbalancers: make(map[string]*loadbalancer),
+		configs:       make(map[string]*config.LoadbalancerConfig),
 		ruleset:       rule.NewSet(),
 		hosts:         make(map[string]*config.HostConfig),
+		mirrors:       make(chan struct{}, maxMirrorsInFlight),
+		logger:        logger,
+	}
+}
+
+// UpsertLoadbalancer upserts the config of a loadbalancer
+// if the lb doesn't exist, it is created
+func (mgr *Manager) UpsertLoadbalancer(cfg *config.LoadbalancerConfig) error {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.configs[cfg.ID] = cfg
+	if lb, ok := mgr.loadbalancers[cfg.ID]; ok {
+		return lb.configure(cfg)
+	}
+	_, err := mgr.getOrCreateLoadbalancer(cfg.ID)
+	return err
+}
+
+// RemoveLoadbalancer resets the config of a loadbalancer to the defaults
+func (mgr *Manager) RemoveLoadbalancer(id string) error {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if _, ok := mgr.configs[id]; !ok {
+		return errors.New("loadbalancer config doesn't exist")
+	}
+	delete(mgr.configs, id)
+	if lb, ok := mgr.loadbalancers[id]; ok {
+		return lb.configure(nil)
 	}
+	return nil
+}
+
+func (mgr *Manager) getOrCreateLoadbalancer(id string) (*loadbalancer, error) {
+	if lb, ok := mgr.loadbalancers[id]; ok {
+		return lb, nil
+	}
+	lb, err := newLoadbalancer(id, mgr.configs[id], mgr.logger)
+	if err != nil {
+		return nil, err
+	}
+	mgr.loadbalancers[id] = lb
+	mgr.logger.Debug("created loadbalancer", "loadbalancer", id)
+	return lb, nil
 }
 
 // UpsertServer upserts a server at a specific loadbalancer
 // if the lb doesn't exist, it is created
 func (mgr *Manager) UpsertServer(cfg *config.HostConfig) error {
-	if oldCfg, ok := mgr.hosts[cfg.ID]; ok {
-		mgr.RemoveServer(oldCfg)
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if oldCfg, ok := mgr.hosts[cfg.ID]; ok && oldCfg.Loadbalancer != cfg.Loadbalancer {
+		mgr.removeServer(oldCfg)
 	}
 	mgr.hosts[cfg.ID] = cfg
-	lb, ok := mgr.loadbalancers[cfg.Loadbalancer]
-	if !ok {
-		lb = NewLoadbalancer()
-		mgr.loadbalancers[cfg.Loadbalancer] = lb
-	}
-	url, err := url.Parse(cfg.URL)
+	lb, err := mgr.getOrCreateLoadbalancer(cfg.Loadbalancer)
 	if err != nil {
 		return err
 	}
-	return lb.UpsertServer(url)
+	return lb.upsertHost(cfg)
 }
 
 // RemoveServer removes a server from a specific loadbalancer
+// if only the ID is given, the rest of the config is looked up
 func (mgr *Manager) RemoveServer(cfg *config.HostConfig) error {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if known, ok := mgr.hosts[cfg.ID]; ok {
+		cfg = known
+	}
+	return mgr.removeServer(cfg)
+}
+
+func (mgr *Manager) removeServer(cfg *config.HostConfig) error {
+	delete(mgr.hosts, cfg.ID)
 	lb, ok := mgr.loadbalancers[cfg.Loadbalancer]
 	if !ok {
 		return errors.New("loadbalancer doesn't exist")
 	}
-	url, err := url.Parse(cfg.URL)
-	if err != nil {
-		return err
-	}
-	return lb.RemoveServer(url)
+	return lb.deleteHost(cfg.ID)
 }
 
 // UpsertRule upserts a loadbalancer rule
@@ -74,17 +140,78 @@ func (mgr *Manager) UpsertRule(rule *rule.Rule) error {
 
 // RemoveRule removes a rule from the current rule-set
 func (mgr *Manager) RemoveRule(id string) error {
+	metrics.ForgetRule(id)
 	return mgr.ruleset.RemoveRule(id)
 }
 
-// ServeHTTP serves HTTP requests by finding the correct loadbalancer and calling it
-func (mgr *Manager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	target, err := mgr.ruleset.GetTarget(req)
+// Rules returns all active loadbalancer rules
+func (mgr *Manager) Rules() []*rule.Rule {
+	return mgr.ruleset.Rules()
+}
+
+// Loadbalancers returns the status of all loadbalancers sorted by ID
+func (mgr *Manager) Loadbalancers() []*LoadbalancerStatus {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	result := make([]*LoadbalancerStatus, 0, len(mgr.loadbalancers))
+	for _, lb := range mgr.loadbalancers {
+		result = append(result, lb.status())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Route finds the rule matching the request and records it in the request info
+func (mgr *Manager) Route(req *http.Request) (*rule.Rule, error) {
+	rule, err := mgr.ruleset.GetRule(req)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(err.Error()))
+		return nil, err
+	}
+	info := requestinfo.FromRequest(req)
+	info.Rule = rule
+	info.RuleID = rule.ID
+	info.Loadbalancer, info.SplitChosen = rule.Choose(req)
+	return rule, nil
+}
+
+// ServeHTTP serves HTTP requests by finding the correct loadbalancer and calling it.
+// Sticky rules get their cookie set to the loadbalancer chosen by weight.
+// If the request has already been routed, the recorded rule is used.
+func (mgr *Manager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	info := requestinfo.FromRequest(req)
+	rule := info.Rule
+	if rule == nil {
+		var err error
+		if rule, err = mgr.Route(req); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	mgr.mutex.RLock()
+	lb, ok := mgr.loadbalancers[info.Loadbalancer]
+	mgr.mutex.RUnlock()
+	// overrides must not pin clients, and sticky clients already have the cookie
+	if rule.Sticky != "" && info.SplitChosen {
+		http.SetCookie(w, &http.Cookie{Name: rule.Sticky, Value: info.Loadbalancer, Path: "/", HttpOnly: true})
+	}
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("loadbalancer has no hosts"))
 		return
 	}
-	lb := mgr.loadbalancers[target]
+	if rule.Rewrite != nil {
+		var err error
+		if req, err = rule.Rewrite.Apply(req); err != nil {
+			mgr.logger.Error("failed to rewrite request", "rule", rule.ID, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			return
+		}
+	}
+	if rule.Mirror != nil {
+		mirrored := mgr.mirror(rule, req)
+		defer mirrored()
+	}
 	lb.ServeHTTP(w, req)
 }
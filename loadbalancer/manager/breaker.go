@@ -0,0 +1,44 @@
+package manager
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/config"
+)
+
+// circuitBreaker answers requests with a fallback response while the whole pool is unhealthy
+type circuitBreaker struct {
+	cfg       config.CircuitBreakerConfig
+	mutex     sync.Mutex
+	lastProbe time.Time
+}
+
+func newCircuitBreaker(cfg *config.CircuitBreakerConfig) *circuitBreaker {
+	breaker := &circuitBreaker{cfg: *cfg}
+	if breaker.cfg.Status == 0 {
+		breaker.cfg.Status = http.StatusServiceUnavailable
+	}
+	if breaker.cfg.ContentType == "" {
+		breaker.cfg.ContentType = "text/plain; charset=utf-8"
+	}
+	return breaker
+}
+
+// probe reports whether a request may pass the open breaker to probe the pool
+func (breaker *circuitBreaker) probe() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if time.Since(breaker.lastProbe) < breaker.cfg.ProbeInterval.Or(5*time.Second) {
+		return false
+	}
+	breaker.lastProbe = time.Now()
+	return true
+}
+
+func (breaker *circuitBreaker) reject(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", breaker.cfg.ContentType)
+	w.WriteHeader(breaker.cfg.Status)
+	w.Write([]byte(breaker.cfg.Body))
+}
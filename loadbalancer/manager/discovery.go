@@ -0,0 +1,291 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/trusch/eve/config"
+)
+
+// discoveredPrefix marks the IDs of hosts found by the DNS discovery
+const discoveredPrefix = "dns:"
+
+// discoverer periodically resolves a DNS name and updates the hosts of a loadbalancer
+type discoverer struct {
+	lb       *loadbalancer
+	cfg      config.DiscoveryConfig
+	client   *dns.Client
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDiscoverer(lb *loadbalancer, cfg *config.DiscoveryConfig) *discoverer {
+	d := &discoverer{
+		lb:     lb,
+		cfg:    *cfg,
+		client: &dns.Client{Timeout: 5 * time.Second},
+		done:   make(chan struct{}),
+	}
+	if d.cfg.Scheme == "" {
+		d.cfg.Scheme = "http"
+	}
+	if d.cfg.Port == 0 {
+		d.cfg.Port = 80
+		if d.cfg.Scheme == "https" {
+			d.cfg.Port = 443
+		}
+	}
+	go d.run()
+	return d
+}
+
+func (d *discoverer) run() {
+	interval := d.cfg.Interval.Or(30 * time.Second)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			next := interval
+			hosts, ttl, err := d.resolve()
+			if err != nil {
+				d.lb.logger.Warn("failed to discover hosts", "name", d.cfg.Name, "error", err)
+				if next > 5*time.Second {
+					next = 5 * time.Second
+				}
+			} else {
+				d.lb.setDiscovered(hosts)
+				if ttl > 0 && ttl < next {
+					next = ttl
+				}
+			}
+			if next < time.Second {
+				next = time.Second
+			}
+			timer.Reset(next)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *discoverer) stop() {
+	d.stopOnce.Do(func() { close(d.done) })
+}
+
+// resolve returns the discovered hosts and the minimal TTL of the records.
+// An empty result is an error, so a name which vanished temporarily doesn't empty the pool.
+func (d *discoverer) resolve() ([]*config.HostConfig, time.Duration, error) {
+	server, err := d.server()
+	if err != nil {
+		return nil, 0, err
+	}
+	var hosts []*config.HostConfig
+	ttl := time.Duration(0)
+	if strings.EqualFold(d.cfg.Type, "SRV") {
+		hosts, ttl, err = d.resolveSRV(server)
+	} else {
+		var ips []net.IP
+		ips, ttl, err = d.resolveIPs(server, d.cfg.Name, nil)
+		for _, ip := range ips {
+			hosts = append(hosts, d.host(ip, d.cfg.Port, 0))
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(hosts) == 0 {
+		return nil, 0, fmt.Errorf("no records found for %v", d.cfg.Name)
+	}
+	return hosts, ttl, nil
+}
+
+// resolveSRV resolves SRV records. The priorities of the records are mapped to priority tiers.
+// Targets which can't be resolved are skipped.
+func (d *discoverer) resolveSRV(server string) ([]*config.HostConfig, time.Duration, error) {
+	resp, err := d.query(server, d.cfg.Name, dns.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := time.Duration(0)
+	var records []*dns.SRV
+	for _, rr := range resp.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			records = append(records, srv)
+			ttl = minTTL(ttl, srv.Hdr.Ttl)
+		}
+	}
+	tiers := make(map[uint16]int)
+	var priorities []int
+	for _, srv := range records {
+		if _, ok := tiers[srv.Priority]; !ok {
+			tiers[srv.Priority] = 0
+			priorities = append(priorities, int(srv.Priority))
+		}
+	}
+	sort.Ints(priorities)
+	for tier, priority := range priorities {
+		tiers[uint16(priority)] = tier
+	}
+	var hosts []*config.HostConfig
+	for _, srv := range records {
+		ips, ipTTL, err := d.resolveIPs(server, srv.Target, resp.Extra)
+		if err != nil {
+			d.lb.logger.Warn("failed to resolve SRV target", "name", d.cfg.Name, "target", srv.Target, "error", err)
+			continue
+		}
+		ttl = minDuration(ttl, ipTTL)
+		for _, ip := range ips {
+			hosts = append(hosts, d.host(ip, int(srv.Port), tiers[srv.Priority]))
+		}
+	}
+	return hosts, ttl, nil
+}
+
+// resolveIPs returns the A and AAAA records of name. Records in extra (the additional section of an SRV response) are used if present.
+func (d *discoverer) resolveIPs(server, name string, extra []dns.RR) ([]net.IP, time.Duration, error) {
+	ips, ttl := collectIPs(dns.Fqdn(name), extra)
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		resp, err := d.query(server, name, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		found, foundTTL := collectIPs(dns.Fqdn(name), resp.Answer)
+		ips = append(ips, found...)
+		ttl = minDuration(ttl, foundTTL)
+	}
+	return ips, ttl, nil
+}
+
+func (d *discoverer) query(server, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	resp, _, err := d.client.Exchange(msg, server)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("query %v %v: %v", dns.TypeToString[qtype], name, dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+func (d *discoverer) server() (string, error) {
+	if d.cfg.Server != "" {
+		if _, _, err := net.SplitHostPort(d.cfg.Server); err != nil {
+			return net.JoinHostPort(d.cfg.Server, "53"), nil
+		}
+		return d.cfg.Server, nil
+	}
+	resolvConf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	if len(resolvConf.Servers) == 0 {
+		return "", fmt.Errorf("no nameserver configured")
+	}
+	return net.JoinHostPort(resolvConf.Servers[0], resolvConf.Port), nil
+}
+
+func (d *discoverer) host(ip net.IP, port, priority int) *config.HostConfig {
+	u := &url.URL{Scheme: d.cfg.Scheme, Host: net.JoinHostPort(ip.String(), strconv.Itoa(port))}
+	return &config.HostConfig{
+		ID:           discoveredPrefix + u.Host,
+		Loadbalancer: d.lb.id,
+		URL:          u.String(),
+		Priority:     priority,
+	}
+}
+
+// collectIPs returns the A and AAAA records of name, following the CNAME chain within records
+func collectIPs(name string, records []dns.RR) ([]net.IP, time.Duration) {
+	names := map[string]bool{strings.ToLower(name): true}
+	ttl := time.Duration(0)
+	// every CNAME can extend the chain by one name, more rounds can't find anything new
+	for range records {
+		grown := false
+		for _, rr := range records {
+			cname, ok := rr.(*dns.CNAME)
+			if !ok || !names[strings.ToLower(cname.Hdr.Name)] || names[strings.ToLower(cname.Target)] {
+				continue
+			}
+			names[strings.ToLower(cname.Target)] = true
+			ttl = minTTL(ttl, cname.Hdr.Ttl)
+			grown = true
+		}
+		if !grown {
+			break
+		}
+	}
+	var ips []net.IP
+	for _, rr := range records {
+		if !names[strings.ToLower(rr.Header().Name)] {
+			continue
+		}
+		switch record := rr.(type) {
+		case *dns.A:
+			ips = append(ips, record.A)
+			ttl = minTTL(ttl, record.Hdr.Ttl)
+		case *dns.AAAA:
+			ips = append(ips, record.AAAA)
+			ttl = minTTL(ttl, record.Hdr.Ttl)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0
+	}
+	return ips, ttl
+}
+
+func minTTL(current time.Duration, ttl uint32) time.Duration {
+	return minDuration(current, time.Duration(ttl)*time.Second)
+}
+
+// minDuration returns the smaller duration, treating 0 as unset
+func minDuration(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// setDiscovered replaces the discovered hosts of the loadbalancer
+func (lb *loadbalancer) setDiscovered(hosts []*config.HostConfig) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	keep := make(map[string]bool)
+	for _, cfg := range hosts {
+		keep[cfg.ID] = true
+		if old, ok := lb.hosts[cfg.ID]; ok && old.cfg.URL == cfg.URL && old.cfg.Priority == cfg.Priority {
+			continue
+		}
+		if err := lb.addHost(cfg); err != nil {
+			lb.logger.Warn("failed to add discovered host", "url", cfg.URL, "error", err)
+			continue
+		}
+		lb.logger.Info("discovered host", "id", cfg.ID, "url", cfg.URL)
+	}
+	lb.dropDiscovered(keep)
+	lb.syncPool()
+}
+
+// dropDiscovered removes the discovered hosts which are not in keep.
+// It must be called with lb.mutex held.
+func (lb *loadbalancer) dropDiscovered(keep map[string]bool) {
+	for id, h := range lb.hosts {
+		if strings.HasPrefix(id, discoveredPrefix) && !keep[id] {
+			lb.removeHost(h)
+			lb.logger.Info("removed discovered host", "id", id, "url", h.cfg.URL)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"log/slog"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/trusch/eve/config"
+)
+
+// startDNS serves the given records on 127.0.0.1 and returns the server address.
+// Names without records answer NXDOMAIN, names with a SERVFAIL record answer SERVFAIL.
+func startDNS(t *testing.T, records map[string][]string) string {
+	t.Helper()
+	zone := make(map[string][]dns.RR)
+	failing := make(map[string]bool)
+	for name, rrs := range records {
+		for _, record := range rrs {
+			if record == "SERVFAIL" {
+				failing[name] = true
+				continue
+			}
+			rr, err := dns.NewRR(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zone[name] = append(zone[name], rr)
+		}
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			q := req.Question[0]
+			rrs, ok := zone[q.Name]
+			switch {
+			case failing[q.Name]:
+				resp.Rcode = dns.RcodeServerFailure
+			case !ok:
+				resp.Rcode = dns.RcodeNameError
+			}
+			for _, rr := range rrs {
+				switch {
+				case rr.Header().Rrtype == q.Qtype, rr.Header().Rrtype == dns.TypeCNAME:
+					resp.Answer = append(resp.Answer, rr)
+				case q.Qtype == dns.TypeSRV:
+					resp.Extra = append(resp.Extra, rr)
+				}
+			}
+			// answer CNAME chains like a recursive resolver
+			for i := 0; i < len(resp.Answer); i++ {
+				if cname, ok := resp.Answer[i].(*dns.CNAME); ok {
+					for _, rr := range zone[cname.Target] {
+						if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+							resp.Answer = append(resp.Answer, rr)
+						}
+					}
+				}
+			}
+			w.WriteMsg(resp)
+		}),
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func newTestDiscoverer(server string, cfg config.DiscoveryConfig) *discoverer {
+	cfg.Server = server
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 80
+	}
+	return &discoverer{
+		lb:     &loadbalancer{id: "test", logger: slog.Default()},
+		cfg:    cfg,
+		client: &dns.Client{Timeout: time.Second},
+	}
+}
+
+func hostURLs(hosts []*config.HostConfig) []string {
+	urls := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		urls = append(urls, h.URL)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func TestDiscoveryA(t *testing.T) {
+	server := startDNS(t, map[string][]string{
+		"app.test.": {"app.test. 60 IN A 10.0.0.1", "app.test. 30 IN A 10.0.0.2", "app.test. 60 IN AAAA ::1"},
+	})
+	d := newTestDiscoverer(server, config.DiscoveryConfig{Name: "app.test", Port: 8080})
+	hosts, ttl, err := d.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := hostURLs(hosts)
+	want := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://[::1]:8080"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if ttl != 30*time.Second {
+		t.Errorf("ttl = %v, want 30s", ttl)
+	}
+}
+
+func TestDiscoveryFollowsCNAME(t *testing.T) {
+	server := startDNS(t, map[string][]string{
+		"app.test.":   {"app.test. 60 IN CNAME lb.test."},
+		"lb.test.":    {"lb.test. 60 IN CNAME edge.test."},
+		"edge.test.":  {"edge.test. 20 IN A 10.0.0.3"},
+		"other.test.": {"other.test. 60 IN A 10.0.0.9"},
+	})
+	d := newTestDiscoverer(server, config.DiscoveryConfig{Name: "app.test"})
+	hosts, ttl, err := d.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hostURLs(hosts); len(got) != 1 || got[0] != "http://10.0.0.3:80" {
+		t.Fatalf("got %v", got)
+	}
+	if ttl != 20*time.Second {
+		t.Errorf("ttl = %v, want 20s", ttl)
+	}
+}
+
+func TestDiscoveryEmptyIsError(t *testing.T) {
+	server := startDNS(t, map[string][]string{
+		"empty.test.": {"empty.test. 60 IN TXT \"nothing\""},
+	})
+	for _, name := range []string{"missing.test", "empty.test"} {
+		d := newTestDiscoverer(server, config.DiscoveryConfig{Name: name})
+		if hosts, _, err := d.resolve(); err == nil {
+			t.Errorf("%v: expected an error, got %v", name, hostURLs(hosts))
+		}
+	}
+}
+
+func TestDiscoverySRV(t *testing.T) {
+	server := startDNS(t, map[string][]string{
+		"_http._tcp.app.test.": {
+			"_http._tcp.app.test. 60 IN SRV 10 1 8080 a.test.",
+			"_http._tcp.app.test. 60 IN SRV 10 1 8081 broken.test.",
+			"_http._tcp.app.test. 60 IN SRV 20 1 9090 b.test.",
+		},
+		"a.test.":      {"a.test. 60 IN A 10.0.0.1"},
+		"b.test.":      {"b.test. 60 IN A 10.0.0.2"},
+		"broken.test.": {"SERVFAIL"},
+	})
+	d := newTestDiscoverer(server, config.DiscoveryConfig{Name: "_http._tcp.app.test", Type: "SRV"})
+	hosts, _, err := d.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priorities := make(map[string]int)
+	for _, h := range hosts {
+		priorities[h.URL] = h.Priority
+	}
+	want := map[string]int{"http://10.0.0.1:8080": 0, "http://10.0.0.2:9090": 1}
+	if len(priorities) != len(want) {
+		t.Fatalf("got %v, want %v", priorities, want)
+	}
+	for url, priority := range want {
+		if got, ok := priorities[url]; !ok || got != priority {
+			t.Fatalf("got %v, want %v", priorities, want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trusch/eve/config"
+)
+
+// FastCGI record types, see https://fast-cgi.github.io/spec
+const (
+	fcgiBeginRequest uint8 = 1
+	fcgiEndRequest   uint8 = 3
+	fcgiParams       uint8 = 4
+	fcgiStdin        uint8 = 5
+	fcgiStdout       uint8 = 6
+	fcgiStderr       uint8 = 7
+
+	fcgiVersion   = 1
+	fcgiResponder = 1
+	fcgiRequestID = 1
+	fcgiMaxWrite  = 65535
+)
+
+// fastCGI forwards requests to FastCGI hosts. It replaces the HTTP forwarder of a loadbalancer.
+type fastCGI struct {
+	lb     *loadbalancer
+	cfg    config.FastCGIConfig
+	dialer *net.Dialer
+	// headerTimeout limits the wait for the response headers, like ResponseHeaderTimeout of HTTP hosts
+	headerTimeout time.Duration
+}
+
+func newFastCGI(lb *loadbalancer, cfg *config.LoadbalancerConfig) *fastCGI {
+	fcgi := &fastCGI{lb: lb, cfg: *cfg.FastCGI, dialer: newDialer(cfg)}
+	if cfg.Transport != nil {
+		fcgi.headerTimeout = time.Duration(cfg.Transport.ResponseHeaderTimeout)
+	}
+	if fcgi.cfg.Index == "" {
+		fcgi.cfg.Index = "index.php"
+	}
+	if fcgi.cfg.SplitPath == "" {
+		fcgi.cfg.SplitPath = ".php"
+	}
+	return fcgi
+}
+
+func (fcgi *fastCGI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	params, err := fcgi.params(req)
+	if err != nil {
+		fcgi.lb.logger.Debug("rejected fastcgi request", "url", req.URL.String(), "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		return
+	}
+	network, addr := "tcp", req.URL.Host
+	if req.URL.Scheme == "unix" {
+		network, addr = "unix", req.URL.Path
+	} else if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "9000")
+	}
+	conn, err := fcgi.dialer.DialContext(req.Context(), network, addr)
+	if err != nil {
+		fcgi.lb.forwardError(w, req, err)
+		return
+	}
+	defer conn.Close()
+	deadline, _ := req.Context().Deadline()
+	conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// abort the exchange when the client goes away
+		select {
+		case <-req.Context().Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	writer := bufio.NewWriter(conn)
+	if err := fcgi.writeRequest(writer, req, params); err != nil {
+		fcgi.lb.forwardError(w, req, err)
+		return
+	}
+	if fcgi.headerTimeout > 0 {
+		if headerDeadline := time.Now().Add(fcgi.headerTimeout); deadline.IsZero() || headerDeadline.Before(deadline) {
+			conn.SetReadDeadline(headerDeadline)
+		}
+	}
+	resp := bufio.NewReader(&fcgiStdoutReader{conn: bufio.NewReader(conn), lb: fcgi.lb})
+	header, err := textproto.NewReader(resp).ReadMIMEHeader()
+	if err != nil {
+		fcgi.lb.forwardError(w, req, err)
+		return
+	}
+	// the body may take longer
+	conn.SetReadDeadline(deadline)
+	status := http.StatusOK
+	if s := header.Get("Status"); s != "" {
+		if status, err = strconv.Atoi(strings.SplitN(s, " ", 2)[0]); err != nil {
+			fcgi.lb.forwardError(w, req, fmt.Errorf("malformed status %q", s))
+			return
+		}
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(status)
+	io.Copy(w, resp)
+}
+
+// writeRequest sends the params and the body of req
+func (fcgi *fastCGI) writeRequest(w *bufio.Writer, req *http.Request, params map[string]string) error {
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(w, fcgiBeginRequest, begin); err != nil {
+		return err
+	}
+	var encoded bytes.Buffer
+	for key, value := range params {
+		writeParam(&encoded, key, value)
+	}
+	if err := writeStream(w, fcgiParams, encoded.Bytes()); err != nil {
+		return err
+	}
+	if req.Body != nil {
+		buf := make([]byte, fcgiMaxWrite)
+		for {
+			n, err := req.Body.Read(buf)
+			if n > 0 {
+				if err := writeRecord(w, fcgiStdin, buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeRecord(w, fcgiStdin, nil); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// params returns the CGI environment of req.
+// The script is looked up by the cleaned path, so it can't leave the document root.
+func (fcgi *fastCGI) params(req *http.Request) (map[string]string, error) {
+	requestURI := req.RequestURI
+	if requestURI == "" {
+		requestURI = req.URL.RequestURI()
+	}
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		u = &url.URL{Path: "/"}
+	}
+	cleaned := path.Clean("/" + u.Path)
+	if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	scriptName, pathInfo := cleaned, ""
+	if i := splitIndex(cleaned, fcgi.cfg.SplitPath); i >= 0 {
+		scriptName, pathInfo = cleaned[:i], cleaned[i:]
+	} else if strings.HasSuffix(cleaned, "/") {
+		scriptName = path.Join(cleaned, fcgi.cfg.Index)
+	}
+	scriptFilename := path.Join(fcgi.cfg.Root, scriptName)
+	if fcgi.cfg.Root != "" {
+		root := strings.TrimSuffix(path.Clean(fcgi.cfg.Root), "/") + "/"
+		if !strings.HasPrefix(scriptFilename, root) {
+			return nil, fmt.Errorf("script %v leaves the document root", scriptName)
+		}
+	}
+	serverName, serverPort := req.Host, "80"
+	if req.TLS != nil {
+		serverPort = "443"
+	}
+	if host, port, err := net.SplitHostPort(req.Host); err == nil {
+		serverName, serverPort = host, port
+	}
+	remoteAddr, remotePort, _ := net.SplitHostPort(req.RemoteAddr)
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "eve",
+		"SERVER_PROTOCOL":   req.Proto,
+		"SERVER_NAME":       serverName,
+		"SERVER_PORT":       serverPort,
+		"REMOTE_ADDR":       remoteAddr,
+		"REMOTE_PORT":       remotePort,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       requestURI,
+		"QUERY_STRING":      u.RawQuery,
+		"DOCUMENT_ROOT":     fcgi.cfg.Root,
+		"DOCUMENT_URI":      scriptName,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"PATH_INFO":         pathInfo,
+		"CONTENT_TYPE":      req.Header.Get("Content-Type"),
+	}
+	if req.ContentLength >= 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if req.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+	for key, values := range req.Header {
+		name := "HTTP_" + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+		params[name] = strings.Join(values, ", ")
+	}
+	delete(params, "HTTP_PROXY")
+	for key, value := range fcgi.cfg.Params {
+		params[key] = value
+	}
+	return params, nil
+}
+
+// splitIndex returns the end of the first occurrence of split in p which ends a path segment, or -1
+func splitIndex(p, split string) int {
+	for offset := 0; ; {
+		i := strings.Index(p[offset:], split)
+		if i < 0 {
+			return -1
+		}
+		end := offset + i + len(split)
+		if end == len(p) || p[end] == '/' {
+			return end
+		}
+		offset += i + 1
+	}
+}
+
+func writeRecord(w io.Writer, recordType uint8, content []byte) error {
+	padding := (8 - len(content)%8) % 8
+	header := []byte{fcgiVersion, recordType, 0, fcgiRequestID, 0, 0, uint8(padding), 0}
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, padding))
+	return err
+}
+
+// writeStream writes content as stream of records terminated by an empty record
+func writeStream(w io.Writer, recordType uint8, content []byte) error {
+	for len(content) > 0 {
+		n := len(content)
+		if n > fcgiMaxWrite {
+			n = fcgiMaxWrite
+		}
+		if err := writeRecord(w, recordType, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return writeRecord(w, recordType, nil)
+}
+
+func writeParam(buf *bytes.Buffer, key, value string) {
+	writeParamLength(buf, len(key))
+	writeParamLength(buf, len(value))
+	buf.WriteString(key)
+	buf.WriteString(value)
+}
+
+func writeParamLength(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(uint8(n))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	buf.Write(b[:])
+}
+
+// fcgiStdoutReader reads the stdout stream of a FastCGI response. Stderr is logged.
+type fcgiStdoutReader struct {
+	conn    *bufio.Reader
+	lb      *loadbalancer
+	pending int
+	padding int
+	done    bool
+}
+
+func (r *fcgiStdoutReader) Read(p []byte) (int, error) {
+	for r.pending == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.padding > 0 {
+			if _, err := r.conn.Discard(r.padding); err != nil {
+				return 0, err
+			}
+			r.padding = 0
+		}
+		var header [8]byte
+		if _, err := io.ReadFull(r.conn, header[:]); err != nil {
+			return 0, err
+		}
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		padding := int(header[6])
+		switch header[1] {
+		case fcgiStdout:
+			r.pending, r.padding = length, padding
+		case fcgiStderr:
+			content := make([]byte, length+padding)
+			if _, err := io.ReadFull(r.conn, content); err != nil {
+				return 0, err
+			}
+			if length > 0 {
+				r.lb.logger.Warn("fastcgi stderr", "message", strings.TrimSpace(string(content[:length])))
+			}
+		case fcgiEndRequest:
+			r.conn.Discard(length + padding)
+			r.done = true
+		default:
+			return 0, errors.New("unexpected fastcgi record type " + strconv.Itoa(int(header[1])))
+		}
+	}
+	if len(p) > r.pending {
+		p = p[:r.pending]
+	}
+	n, err := r.conn.Read(p)
+	r.pending -= n
+	return n, err
+}
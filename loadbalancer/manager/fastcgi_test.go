@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/trusch/eve/config"
+)
+
+func TestFastCGIResponseHeaderTimeout(t *testing.T) {
+	// the host accepts the request but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	cfg := &config.LoadbalancerConfig{
+		ID:        "test",
+		FastCGI:   &config.FastCGIConfig{Root: "/var/www"},
+		Transport: &config.TransportConfig{ResponseHeaderTimeout: config.Duration(100 * time.Millisecond)},
+	}
+	lb := &loadbalancer{id: "test", logger: slog.Default()}
+	fcgi := newFastCGI(lb, cfg)
+	req := httptest.NewRequest(http.MethodGet, "http://app.test/index.php", nil)
+	req.URL.Scheme, req.URL.Host = "tcp", ln.Addr().String()
+	w := httptest.NewRecorder()
+	start := time.Now()
+	fcgi.ServeHTTP(w, req)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %v, want 504", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("waited %v for the response headers", elapsed)
+	}
+}
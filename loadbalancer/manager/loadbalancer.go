@@ -0,0 +1,358 @@
+package manager
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/config"
+	"github.com/trusch/eve/metrics"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/roundrobin"
+	"github.com/vulcand/oxy/utils"
+)
+
+// loadbalancer is a pool of hosts. In fact it's a chain: [buffer ->] [retry ->] rebalancer -> roundrobin -> upstream -> forward|fastcgi
+type loadbalancer struct {
+	id         string
+	mutex      sync.RWMutex
+	cfg        *config.LoadbalancerConfig
+	hosts      map[string]*host
+	rebalancer *roundrobin.Rebalancer
+	handler    http.Handler
+	transport  *http.Transport
+	outliers   *outlierDetector
+	breaker    *circuitBreaker
+	slowStart  *slowStart
+	discovery  *discoverer
+	tier       int
+	logger     *slog.Logger
+}
+
+// host is a registered host of a loadbalancer
+type host struct {
+	cfg     *config.HostConfig
+	url     *url.URL
+	added   time.Time
+	weight  int
+	ejected bool
+}
+
+func newLoadbalancer(id string, cfg *config.LoadbalancerConfig, logger *slog.Logger) (*loadbalancer, error) {
+	lb := &loadbalancer{
+		id:     id,
+		hosts:  make(map[string]*host),
+		logger: logger.With("loadbalancer", id),
+	}
+	if err := lb.configure(cfg); err != nil {
+		return nil, err
+	}
+	return lb, nil
+}
+
+// configure applies cfg by rebuilding the handler chain and re-adding all hosts
+func (lb *loadbalancer) configure(cfg *config.LoadbalancerConfig) error {
+	if cfg == nil {
+		cfg = &config.LoadbalancerConfig{ID: lb.id}
+	}
+	if cfg.SlowStart != nil {
+		if err := checkSlowStart(cfg.SlowStart); err != nil {
+			return err
+		}
+	}
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return err
+	}
+	fwd, err := forward.New(
+		forward.RoundTripper(transport),
+		// the Host header is set by upstream
+		forward.PassHostHeader(true),
+		forward.ErrorHandler(utils.ErrorHandlerFunc(lb.forwardError)),
+	)
+	if err != nil {
+		return err
+	}
+	next := http.Handler(&unixSockets{next: fwd})
+	if cfg.FastCGI != nil {
+		next = newFastCGI(lb, cfg)
+	}
+	rr, err := roundrobin.New(&upstream{lb: lb, next: next, passHost: cfg.FastCGI != nil})
+	if err != nil {
+		return err
+	}
+	rb, err := roundrobin.NewRebalancer(rr)
+	if err != nil {
+		return err
+	}
+	handler := http.Handler(rb)
+	if cfg.Retry != nil {
+		retry := newRetryPolicy(lb.id, cfg.Retry, lb.alternative)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			retry.serve(rb, w, req)
+		})
+	}
+	if cfg.Buffering != nil {
+		if handler, err = newBuffer(handler, cfg.Buffering); err != nil {
+			return err
+		}
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if lb.outliers != nil {
+		lb.outliers.stop()
+		lb.outliers = nil
+	}
+	lb.breaker = nil
+	if lb.slowStart != nil {
+		lb.slowStart.stop()
+		lb.slowStart = nil
+	}
+	if cfg.SlowStart != nil {
+		lb.slowStart = newSlowStart(lb, cfg.SlowStart)
+	}
+	if lb.discovery != nil {
+		lb.discovery.stop()
+		lb.discovery = nil
+	}
+	if cfg.Discovery != nil {
+		lb.discovery = newDiscoverer(lb, cfg.Discovery)
+	} else {
+		lb.dropDiscovered(nil)
+	}
+	if cfg.CircuitBreaker != nil {
+		lb.breaker = newCircuitBreaker(cfg.CircuitBreaker)
+	}
+	if cfg.OutlierDetection != nil || cfg.CircuitBreaker != nil {
+		lb.outliers = newOutlierDetector(lb, cfg.OutlierDetection)
+	}
+	if lb.transport != nil {
+		lb.transport.CloseIdleConnections()
+	}
+	lb.cfg = cfg
+	lb.rebalancer = rb
+	lb.handler = handler
+	lb.transport = transport
+	now := time.Now()
+	for _, h := range lb.hosts {
+		if lb.outliers != nil {
+			lb.outliers.addHost(h.url.String())
+		}
+		h.ejected = false
+		h.weight = lb.hostWeight(h, now)
+	}
+	return lb.syncPool()
+}
+
+func (lb *loadbalancer) upsertHost(cfg *config.HostConfig) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if err := lb.addHost(cfg); err != nil {
+		return err
+	}
+	return lb.syncPool()
+}
+
+// addHost adds or replaces a host without syncing the pool.
+// It must be called with lb.mutex held.
+func (lb *loadbalancer) addHost(cfg *config.HostConfig) error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return err
+	}
+	h := &host{cfg: cfg, url: u, added: time.Now()}
+	if old, ok := lb.hosts[cfg.ID]; ok {
+		if old.url.String() == u.String() {
+			// an update of an existing host doesn't restart its slow-start or ejection
+			h.added, h.ejected = old.added, old.ejected
+		}
+		lb.removeHost(old)
+	}
+	lb.hosts[cfg.ID] = h
+	if lb.outliers != nil {
+		lb.outliers.addHost(u.String())
+	}
+	h.weight = lb.hostWeight(h, time.Now())
+	return nil
+}
+
+func (lb *loadbalancer) deleteHost(id string) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	h, ok := lb.hosts[id]
+	if !ok {
+		return errors.New("host doesn't exist")
+	}
+	lb.removeHost(h)
+	return lb.syncPool()
+}
+
+func (lb *loadbalancer) removeHost(h *host) {
+	delete(lb.hosts, h.cfg.ID)
+	if lb.outliers != nil {
+		lb.outliers.removeHost(h.url.String())
+	}
+	metrics.ForgetHost(lb.id, hostLabel(h.url))
+}
+
+// eject takes a host out of the pool without forgetting it
+func (lb *loadbalancer) eject(key string) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	for _, h := range lb.hosts {
+		if h.url.String() == key {
+			h.ejected = true
+			lb.logger.Warn("ejected host", "id", h.cfg.ID, "url", key)
+		}
+	}
+	lb.syncPool()
+}
+
+// restore puts an ejected host back into the pool
+func (lb *loadbalancer) restore(key string) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	for _, h := range lb.hosts {
+		if h.url.String() == key {
+			h.ejected = false
+			lb.logger.Info("restored host", "id", h.cfg.ID, "url", key)
+		}
+	}
+	lb.syncPool()
+}
+
+// hostWeight returns the roundrobin weight of a host, taking a running slow-start into account
+func (lb *loadbalancer) hostWeight(h *host, now time.Time) int {
+	if lb.slowStart == nil {
+		return fullWeight
+	}
+	return lb.slowStart.weight(h.added, now)
+}
+
+// rampUp updates the weights of the hosts in slow-start
+func (lb *loadbalancer) rampUp() {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	active := make(map[string]bool)
+	for _, u := range lb.rebalancer.Servers() {
+		active[u.String()] = true
+	}
+	now := time.Now()
+	for _, h := range lb.hosts {
+		weight := lb.hostWeight(h, now)
+		if weight == h.weight {
+			continue
+		}
+		h.weight = weight
+		// hosts out of the pool get their weight when they are added again
+		if active[h.url.String()] {
+			lb.rebalancer.UpsertServer(h.url, roundrobin.Weight(weight))
+		}
+	}
+}
+
+// observe records the result of a forwarded request
+func (lb *loadbalancer) observe(key string, status int, latency time.Duration) {
+	lb.mutex.RLock()
+	outliers := lb.outliers
+	lb.mutex.RUnlock()
+	if outliers != nil {
+		outliers.observe(key, status, latency)
+	}
+}
+
+func (lb *loadbalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	lb.mutex.RLock()
+	handler, breaker, outliers := lb.handler, lb.breaker, lb.outliers
+	empty := len(lb.hosts) == 0
+	lb.mutex.RUnlock()
+	if breaker != nil {
+		open := empty || outliers.allUnhealthy()
+		metrics.CircuitBreakerOpen.WithLabelValues(lb.id).Set(boolToFloat(open))
+		if open && !breaker.probe() {
+			breaker.reject(w)
+			return
+		}
+	}
+	handler.ServeHTTP(w, req)
+}
+
+// forwardError answers failed upstream requests and records the error for the retry policy
+func (lb *loadbalancer) forwardError(w http.ResponseWriter, req *http.Request, err error) {
+	if a := attemptFromContext(req.Context()); a != nil {
+		a.err = err
+	}
+	status := http.StatusBadGateway
+	if isTimeout(err) {
+		status = http.StatusGatewayTimeout
+	}
+	lb.logger.Debug("upstream request failed", "url", req.URL.String(), "error", err)
+	w.WriteHeader(status)
+	w.Write([]byte(http.StatusText(status)))
+}
+
+// alternative picks an active host which has not been tried yet. It returns nil if there is none.
+func (lb *loadbalancer) alternative(excluded map[string]bool) *url.URL {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+	var candidates []*url.URL
+	for _, u := range lb.rebalancer.Servers() {
+		if !excluded[u.String()] {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+func (lb *loadbalancer) status() *LoadbalancerStatus {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+	status := &LoadbalancerStatus{ID: lb.id, Config: lb.cfg, ActiveTier: lb.tier, Hosts: []*HostStatus{}}
+	if lb.cfg.TLS != nil && lb.cfg.TLS.KeyPem != "" {
+		// never expose the decrypted client key
+		cfg, tlsConfig := *lb.cfg, *lb.cfg.TLS
+		tlsConfig.KeyPem = "<redacted>"
+		cfg.TLS = &tlsConfig
+		status.Config = &cfg
+	}
+	active := make(map[string]bool)
+	for _, u := range lb.rebalancer.Servers() {
+		active[u.String()] = true
+	}
+	for _, h := range lb.hosts {
+		key := h.url.String()
+		hostStatus := &HostStatus{
+			ID:       h.cfg.ID,
+			URL:      h.cfg.URL,
+			Priority: h.cfg.Priority,
+			Healthy:  lb.healthy(h),
+			Active:   active[key],
+			Weight:   h.weight,
+		}
+		if lb.outliers != nil {
+			hostStatus.EjectedUntil = lb.outliers.ejectedUntil(key)
+		}
+		status.Hosts = append(status.Hosts, hostStatus)
+	}
+	if lb.breaker != nil {
+		status.CircuitOpen = len(lb.hosts) == 0 || lb.outliers.allUnhealthy()
+	}
+	sort.Slice(status.Hosts, func(i, j int) bool { return status.Hosts[i].ID < status.Hosts[j].ID })
+	return status
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
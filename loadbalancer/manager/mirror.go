@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/loadbalancer/rule"
+	"github.com/trusch/eve/metrics"
+	"github.com/trusch/eve/requestinfo"
+)
+
+const (
+	// maxMirrorsInFlight caps the concurrently mirrored requests, further ones are dropped
+	maxMirrorsInFlight = 256
+	// mirrorTimeout limits the lifetime of a mirrored request
+	mirrorTimeout = 30 * time.Second
+)
+
+// mirror copies a request to the shadow loadbalancer of a rule.
+// It never blocks on the shadow loadbalancer: the copy is sent in the background and dropped if too many are in flight.
+// The request body is copied while the original request reads it, so the copy is sent once it has been read completely.
+// The returned function must be called when the original request is done.
+func (mgr *Manager) mirror(r *rule.Rule, req *http.Request) func() {
+	cfg := r.Mirror
+	if cfg.Percent != nil && rand.Float64()*100 >= *cfg.Percent {
+		return func() {}
+	}
+	// upgraded connections can't be replayed
+	if req.Header.Get("Upgrade") != "" {
+		return func() {}
+	}
+	mgr.mutex.RLock()
+	lb, ok := mgr.loadbalancers[cfg.Target]
+	mgr.mutex.RUnlock()
+	if !ok {
+		metrics.MirrorRequests.WithLabelValues(r.ID, cfg.Target, "dropped").Inc()
+		return func() {}
+	}
+	select {
+	case mgr.mirrors <- struct{}{}:
+	default:
+		metrics.MirrorRequests.WithLabelValues(r.ID, cfg.Target, "dropped").Inc()
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	info := &requestinfo.Info{Start: time.Now(), Rule: r, RuleID: r.ID, Loadbalancer: cfg.Target}
+	shadow := req.Clone(requestinfo.NewContext(ctx, info))
+	shadow.Body = http.NoBody
+	var body *mirrorBody
+	if req.Body != nil && req.Body != http.NoBody {
+		maxBody := cfg.MaxBodyBytes
+		if maxBody == 0 {
+			maxBody = 64 * 1024
+		}
+		body = &mirrorBody{ReadCloser: req.Body, max: maxBody, done: make(chan struct{})}
+		req.Body = body
+	}
+	go func() {
+		defer func() { <-mgr.mirrors }()
+		defer cancel()
+		if body != nil {
+			<-body.done
+			if body.result != "" {
+				metrics.MirrorRequests.WithLabelValues(r.ID, cfg.Target, body.result).Inc()
+				return
+			}
+			shadow.Body = io.NopCloser(bytes.NewReader(body.buf.Bytes()))
+		}
+		w := &discardWriter{header: make(http.Header), status: http.StatusOK}
+		lb.ServeHTTP(w, shadow)
+		result := "ok"
+		if w.status >= http.StatusInternalServerError {
+			result = "error"
+		}
+		metrics.MirrorRequests.WithLabelValues(r.ID, cfg.Target, result).Inc()
+		metrics.MirrorRequestDuration.WithLabelValues(r.ID, cfg.Target).Observe(time.Since(info.Start).Seconds())
+		mgr.logger.Debug("mirrored request", "rule", r.ID, "loadbalancer", cfg.Target, "status", w.status)
+	}()
+	if body == nil {
+		return func() {}
+	}
+	return func() { body.finish("incomplete") }
+}
+
+// mirrorBody copies the request body for the mirrored request while the original request reads it
+type mirrorBody struct {
+	io.ReadCloser
+	max int64
+
+	mutex    sync.Mutex
+	buf      bytes.Buffer
+	finished bool
+	// result is empty if the body was read completely, otherwise it is the metrics result of the dropped copy
+	result string
+	// done is closed when the copy is finished
+	done chan struct{}
+}
+
+func (body *mirrorBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.mutex.Lock()
+	defer body.mutex.Unlock()
+	if body.finished {
+		return n, err
+	}
+	if int64(body.buf.Len()+n) > body.max {
+		body.finishLocked("too_large")
+		return n, err
+	}
+	body.buf.Write(p[:n])
+	if err == io.EOF {
+		body.finishLocked("")
+	}
+	return n, err
+}
+
+// finish ends the copy unless it is finished already
+func (body *mirrorBody) finish(result string) {
+	body.mutex.Lock()
+	defer body.mutex.Unlock()
+	if !body.finished {
+		body.finishLocked(result)
+	}
+}
+
+func (body *mirrorBody) finishLocked(result string) {
+	body.finished = true
+	body.result = result
+	if result != "" {
+		body.buf = bytes.Buffer{}
+	}
+	close(body.done)
+}
+
+// discardWriter swallows the responses of mirrored requests
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
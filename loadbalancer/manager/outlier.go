@@ -0,0 +1,200 @@
+package manager
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/config"
+	"github.com/trusch/eve/metrics"
+)
+
+// outlierDetector tracks the error rate and latency of the hosts of a loadbalancer
+// and ejects hosts which exceed the configured limits
+type outlierDetector struct {
+	lb       *loadbalancer
+	cfg      config.OutlierDetectionConfig
+	mutex    sync.Mutex
+	hosts    map[string]*hostStats
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type hostStats struct {
+	requests     int
+	errors       int
+	latency      time.Duration
+	unhealthy    bool
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// newOutlierDetector returns a running detector. Without cfg, the hosts
+// are only tracked for the circuit breaker but never ejected.
+func newOutlierDetector(lb *loadbalancer, cfg *config.OutlierDetectionConfig) *outlierDetector {
+	detector := &outlierDetector{
+		lb:    lb,
+		hosts: make(map[string]*hostStats),
+		done:  make(chan struct{}),
+	}
+	if cfg != nil {
+		detector.cfg = *cfg
+		if detector.cfg.MaxEjectionPercent == 0 {
+			detector.cfg.MaxEjectionPercent = 50
+		}
+	}
+	if detector.cfg.MinRequests == 0 {
+		detector.cfg.MinRequests = 5
+	}
+	if detector.cfg.MaxErrorRate == 0 {
+		detector.cfg.MaxErrorRate = 0.5
+	}
+	go detector.run()
+	return detector
+}
+
+func (detector *outlierDetector) run() {
+	ticker := time.NewTicker(detector.cfg.Interval.Or(10 * time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			detector.evaluate()
+		case <-detector.done:
+			return
+		}
+	}
+}
+
+func (detector *outlierDetector) stop() {
+	detector.stopOnce.Do(func() { close(detector.done) })
+}
+
+func (detector *outlierDetector) addHost(key string) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	if _, ok := detector.hosts[key]; !ok {
+		detector.hosts[key] = &hostStats{}
+	}
+}
+
+func (detector *outlierDetector) removeHost(key string) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	delete(detector.hosts, key)
+}
+
+func (detector *outlierDetector) observe(key string, status int, latency time.Duration) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	stats, ok := detector.hosts[key]
+	if !ok {
+		return
+	}
+	stats.requests++
+	stats.latency += latency
+	if status >= http.StatusInternalServerError {
+		stats.errors++
+	}
+}
+
+// evaluate checks all hosts, ejects outliers and restores hosts whose ejection time is over
+func (detector *outlierDetector) evaluate() {
+	var eject, restore []string
+	now := time.Now()
+	detector.mutex.Lock()
+	ejected := 0
+	for _, stats := range detector.hosts {
+		if !stats.ejectedUntil.IsZero() {
+			ejected++
+		}
+	}
+	// round up, so small pools can eject at least one host. Without outlier detection
+	// MaxEjectionPercent is 0 and nothing is ejected.
+	maxEjected := (len(detector.hosts)*detector.cfg.MaxEjectionPercent + 99) / 100
+	for key, stats := range detector.hosts {
+		if !stats.ejectedUntil.IsZero() {
+			if now.After(stats.ejectedUntil) {
+				stats.ejectedUntil = time.Time{}
+				stats.unhealthy = false
+				restore = append(restore, key)
+			}
+			continue
+		}
+		// unhealthy hosts only get probe requests, so any request counts for them
+		if stats.requests >= detector.cfg.MinRequests || (stats.unhealthy && stats.requests > 0) {
+			errorRate := float64(stats.errors) / float64(stats.requests)
+			meanLatency := stats.latency / time.Duration(stats.requests)
+			maxLatency := time.Duration(detector.cfg.MaxLatency)
+			stats.unhealthy = errorRate > detector.cfg.MaxErrorRate || (maxLatency > 0 && meanLatency > maxLatency)
+			if !stats.unhealthy && stats.ejections > 0 {
+				stats.ejections--
+			}
+		}
+		if stats.unhealthy && ejected < maxEjected {
+			backoff := detector.cfg.BaseEjectionTime.Or(30*time.Second) << uint(stats.ejections)
+			if max := detector.cfg.MaxEjectionTime.Or(5 * time.Minute); backoff > max || backoff <= 0 {
+				backoff = max
+			}
+			stats.ejections++
+			stats.ejectedUntil = now.Add(backoff)
+			ejected++
+			eject = append(eject, key)
+		}
+		stats.requests, stats.errors, stats.latency = 0, 0, 0
+	}
+	detector.mutex.Unlock()
+
+	for _, key := range eject {
+		metrics.UpstreamEjections.WithLabelValues(detector.lb.id, hostOf(key)).Inc()
+		detector.lb.eject(key)
+	}
+	for _, key := range restore {
+		detector.lb.restore(key)
+	}
+}
+
+func (detector *outlierDetector) healthy(key string) bool {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	stats, ok := detector.hosts[key]
+	return !ok || !stats.unhealthy
+}
+
+func (detector *outlierDetector) ejectedUntil(key string) time.Time {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	if stats, ok := detector.hosts[key]; ok {
+		return stats.ejectedUntil
+	}
+	return time.Time{}
+}
+
+// allUnhealthy reports whether no host of the pool is healthy
+func (detector *outlierDetector) allUnhealthy() bool {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	for _, stats := range detector.hosts {
+		if !stats.unhealthy {
+			return false
+		}
+	}
+	return len(detector.hosts) > 0
+}
+
+// hostOf returns the host label of a host key
+func hostOf(key string) string {
+	if u, err := url.Parse(key); err == nil {
+		return hostLabel(u)
+	}
+	return key
+}
+
+// hostLabel returns the host of a URL, or the socket path of unix:// URLs
+func hostLabel(u *url.URL) string {
+	if u.Scheme == "unix" {
+		return u.Path
+	}
+	return u.Host
+}
@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/trusch/eve/config"
+)
+
+func newTestOutlierLB(t *testing.T, cfg *config.LoadbalancerConfig, hosts ...string) *loadbalancer {
+	t.Helper()
+	lb, err := newLoadbalancer("test", cfg, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(lb.outliers.stop)
+	for _, url := range hosts {
+		if err := lb.upsertHost(&config.HostConfig{ID: url, URL: url, Loadbalancer: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lb
+}
+
+// failAll makes every host fail all its requests in the current interval
+func failAll(lb *loadbalancer, hosts ...string) {
+	for _, url := range hosts {
+		for i := 0; i < 10; i++ {
+			lb.outliers.observe(url, http.StatusBadGateway, time.Millisecond)
+		}
+	}
+}
+
+func ejectedHosts(lb *loadbalancer, hosts ...string) int {
+	ejected := 0
+	for _, url := range hosts {
+		if !lb.outliers.ejectedUntil(url).IsZero() {
+			ejected++
+		}
+	}
+	return ejected
+}
+
+func TestOutlierEjection(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		want  int
+	}{
+		// 50% of one host rounds up to one
+		{"single host", []string{"http://10.0.0.1"}, 1},
+		{"three hosts", []string{"http://10.0.0.1", "http://10.0.0.2", "http://10.0.0.3"}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lb := newTestOutlierLB(t, &config.LoadbalancerConfig{ID: "test", OutlierDetection: &config.OutlierDetectionConfig{}}, test.hosts...)
+			failAll(lb, test.hosts...)
+			lb.outliers.evaluate()
+			if got := ejectedHosts(lb, test.hosts...); got != test.want {
+				t.Fatalf("ejected %v hosts, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerNeverEjects(t *testing.T) {
+	hosts := []string{"http://10.0.0.1", "http://10.0.0.2"}
+	lb := newTestOutlierLB(t, &config.LoadbalancerConfig{ID: "test", CircuitBreaker: &config.CircuitBreakerConfig{}}, hosts...)
+	failAll(lb, hosts...)
+	lb.outliers.evaluate()
+	if got := ejectedHosts(lb, hosts...); got != 0 {
+		t.Fatalf("ejected %v hosts without outlier detection", got)
+	}
+	if !lb.outliers.allUnhealthy() {
+		t.Fatal("failing hosts not tracked as unhealthy for the circuit breaker")
+	}
+	if got := len(lb.rebalancer.Servers()); got != len(hosts) {
+		t.Fatalf("%v hosts in the pool, want %v", got, len(hosts))
+	}
+}
@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"sort"
+
+	"github.com/trusch/eve/metrics"
+	"github.com/vulcand/oxy/roundrobin"
+)
+
+// syncPool brings the rebalancer in line with the hosts:
+// only the hosts of the active tier which are not ejected get traffic.
+// It must be called with lb.mutex held.
+func (lb *loadbalancer) syncPool() error {
+	tier := lb.selectTier()
+	want := make(map[string]*host)
+	for _, h := range lb.hosts {
+		if !h.ejected && h.cfg.Priority == tier {
+			want[h.url.String()] = h
+		}
+	}
+	current := make(map[string]bool)
+	for _, u := range lb.rebalancer.Servers() {
+		key := u.String()
+		current[key] = true
+		if want[key] == nil {
+			lb.rebalancer.RemoveServer(u)
+		}
+	}
+	for key, h := range want {
+		if !current[key] {
+			if err := lb.rebalancer.UpsertServer(h.url, roundrobin.Weight(h.weight)); err != nil {
+				return err
+			}
+		}
+	}
+	if tier != lb.tier {
+		lb.logger.Warn("switched priority tier", "from", lb.tier, "to", tier)
+		lb.tier = tier
+	}
+	metrics.ActiveTier.WithLabelValues(lb.id).Set(float64(tier))
+	return nil
+}
+
+// selectTier returns the tier with the lowest priority number which has enough healthy capacity.
+// If no tier has, the first tier with any healthy host is used.
+func (lb *loadbalancer) selectTier() int {
+	type capacity struct{ total, healthy int }
+	tiers := make(map[int]*capacity)
+	for _, h := range lb.hosts {
+		c, ok := tiers[h.cfg.Priority]
+		if !ok {
+			c = &capacity{}
+			tiers[h.cfg.Priority] = c
+		}
+		c.total++
+		if lb.healthy(h) {
+			c.healthy++
+		}
+	}
+	if len(tiers) == 0 {
+		return 0
+	}
+	priorities := make([]int, 0, len(tiers))
+	for p := range tiers {
+		priorities = append(priorities, p)
+	}
+	sort.Ints(priorities)
+	threshold := 70
+	if lb.cfg.Failover != nil && lb.cfg.Failover.MinHealthyPercent > 0 {
+		threshold = lb.cfg.Failover.MinHealthyPercent
+	}
+	fallback := -1
+	for _, p := range priorities {
+		c := tiers[p]
+		if c.healthy*100 >= threshold*c.total {
+			return p
+		}
+		if fallback == -1 && c.healthy > 0 {
+			fallback = p
+		}
+	}
+	if fallback != -1 {
+		return fallback
+	}
+	return priorities[0]
+}
+
+// healthy reports whether a host is neither ejected nor flagged by the outlier detection
+func (lb *loadbalancer) healthy(h *host) bool {
+	if h.ejected {
+		return false
+	}
+	return lb.outliers == nil || lb.outliers.healthy(h.url.String())
+}
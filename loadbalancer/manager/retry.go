@@ -0,0 +1,288 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/config"
+	"github.com/trusch/eve/metrics"
+)
+
+// retryPolicy retries failed requests on other hosts of the pool
+type retryPolicy struct {
+	lb     string
+	cfg    config.RetryConfig
+	budget *retryBudget
+	// alternative picks a host which is not excluded, nil if there is none
+	alternative func(excluded map[string]bool) *url.URL
+}
+
+// attempt is the state of one try to forward a request
+type attempt struct {
+	excluded map[string]bool
+	host     string
+	err      error
+}
+
+type attemptKey struct{}
+
+func attemptFromContext(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+func newRetryPolicy(lb string, cfg *config.RetryConfig, alternative func(map[string]bool) *url.URL) *retryPolicy {
+	policy := &retryPolicy{lb: lb, cfg: *cfg, alternative: alternative}
+	if policy.cfg.MaxAttempts == 0 {
+		policy.cfg.MaxAttempts = 2
+	}
+	if policy.cfg.MaxBodyBytes == 0 {
+		policy.cfg.MaxBodyBytes = 64 * 1024
+	}
+	if policy.cfg.BudgetPercent == 0 {
+		policy.cfg.BudgetPercent = 20
+	}
+	if policy.cfg.BudgetMinPerSecond == 0 {
+		policy.cfg.BudgetMinPerSecond = 3
+	}
+	if !policy.cfg.OnConnectError && !policy.cfg.OnTimeout && len(policy.cfg.OnStatus) == 0 {
+		policy.cfg.OnConnectError = true
+	}
+	policy.budget = newRetryBudget(policy.cfg.BudgetPercent, policy.cfg.BudgetMinPerSecond)
+	return policy
+}
+
+func (policy *retryPolicy) serve(next http.Handler, w http.ResponseWriter, req *http.Request) {
+	policy.budget.request()
+	if !policy.cfg.Force && !isIdempotent(req.Method) {
+		next.ServeHTTP(w, req)
+		return
+	}
+	body, ok := bufferBody(req, policy.cfg.MaxBodyBytes)
+	if !ok {
+		next.ServeHTTP(w, req)
+		return
+	}
+	state := &attempt{excluded: make(map[string]bool)}
+	ctx := context.WithValue(req.Context(), attemptKey{}, state)
+	for n := 1; ; n++ {
+		state.host, state.err = "", nil
+		attemptReq := req.WithContext(ctx)
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		rw := &retryWriter{
+			ResponseWriter: w,
+			header:         make(http.Header),
+			shouldRetry: func(status int) bool {
+				return n < policy.cfg.MaxAttempts && policy.retryable(status, state.err) &&
+					policy.untriedLeft(state) && policy.budget.allowRetry()
+			},
+		}
+		next.ServeHTTP(rw, attemptReq)
+		if !rw.retry {
+			return
+		}
+		metrics.UpstreamRetries.WithLabelValues(policy.lb).Inc()
+		if state.host != "" {
+			state.excluded[state.host] = true
+		}
+	}
+}
+
+// untriedLeft reports whether a host is left which failed neither in this nor in a previous attempt.
+// Retrying on a host which just failed is pointless.
+func (policy *retryPolicy) untriedLeft(state *attempt) bool {
+	excluded := make(map[string]bool, len(state.excluded)+1)
+	for host := range state.excluded {
+		excluded[host] = true
+	}
+	if state.host != "" {
+		excluded[state.host] = true
+	}
+	return policy.alternative(excluded) != nil
+}
+
+func (policy *retryPolicy) retryable(status int, err error) bool {
+	if err != nil {
+		if isTimeout(err) {
+			return policy.cfg.OnTimeout
+		}
+		return policy.cfg.OnConnectError
+	}
+	for _, s := range policy.cfg.OnStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// bufferBody reads the request body so it can be replayed.
+// If the body is bigger than max, the request body is restored and false is returned.
+func bufferBody(req *http.Request, max int64) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil || int64(len(body)) > max {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+	return body, true
+}
+
+// retryWriter decides on the status code whether the response is passed to the client
+// or discarded in favour of a retry. Headers are kept per attempt until then.
+type retryWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	shouldRetry func(status int) bool
+	committed   bool
+	retry       bool
+}
+
+func (rw *retryWriter) Header() http.Header {
+	if rw.committed {
+		return rw.ResponseWriter.Header()
+	}
+	return rw.header
+}
+
+func (rw *retryWriter) WriteHeader(status int) {
+	if rw.committed || rw.retry {
+		return
+	}
+	if rw.shouldRetry(status) {
+		rw.retry = true
+		return
+	}
+	rw.commit()
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *retryWriter) Write(data []byte) (int, error) {
+	if !rw.committed && !rw.retry {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.retry {
+		return len(data), nil
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+func (rw *retryWriter) Flush() {
+	if rw.committed {
+		if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// Hijack passes upgraded connections through, they are never retried
+func (rw *retryWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if !rw.committed {
+		rw.commit()
+	}
+	return hijacker.Hijack()
+}
+
+func (rw *retryWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *retryWriter) commit() {
+	rw.committed = true
+	dst := rw.ResponseWriter.Header()
+	for key, values := range rw.header {
+		dst[key] = values
+	}
+}
+
+// retryBudget limits retries to a percentage of the requests of the last 10 seconds
+type retryBudget struct {
+	mutex        sync.Mutex
+	percent      float64
+	minPerSecond int
+	buckets      [10]struct {
+		second   int64
+		requests int
+		retries  int
+	}
+}
+
+func newRetryBudget(percent float64, minPerSecond int) *retryBudget {
+	return &retryBudget{percent: percent, minPerSecond: minPerSecond}
+}
+
+func (budget *retryBudget) bucket(now int64) int {
+	i := int(now % int64(len(budget.buckets)))
+	if budget.buckets[i].second != now {
+		budget.buckets[i].second = now
+		budget.buckets[i].requests = 0
+		budget.buckets[i].retries = 0
+	}
+	return i
+}
+
+func (budget *retryBudget) request() {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.buckets[budget.bucket(time.Now().Unix())].requests++
+}
+
+// allowRetry reports whether another retry fits into the budget and counts it if so
+func (budget *retryBudget) allowRetry() bool {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	now := time.Now().Unix()
+	current := budget.bucket(now)
+	requests, retries := 0, 0
+	for _, b := range budget.buckets {
+		if now-b.second < int64(len(budget.buckets)) {
+			requests += b.requests
+			retries += b.retries
+		}
+	}
+	limit := float64(requests) * budget.percent / 100
+	if min := float64(budget.minPerSecond * len(budget.buckets)); limit < min {
+		limit = min
+	}
+	if float64(retries) >= limit {
+		return false
+	}
+	budget.buckets[current].retries++
+	return true
+}
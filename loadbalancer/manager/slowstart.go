@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/config"
+)
+
+// fullWeight is the roundrobin weight of a host which is not ramping up
+const fullWeight = 100
+
+// slowStart ramps up the weight of newly added hosts
+type slowStart struct {
+	lb       *loadbalancer
+	cfg      config.SlowStartConfig
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// checkSlowStart validates cfg before the loadbalancer is reconfigured
+func checkSlowStart(cfg *config.SlowStartConfig) error {
+	switch cfg.Mode {
+	case "", "linear", "exponential":
+		return nil
+	}
+	return errors.New("slow start: unknown mode '" + cfg.Mode + "'")
+}
+
+func newSlowStart(lb *loadbalancer, cfg *config.SlowStartConfig) *slowStart {
+	ramp := &slowStart{
+		lb:   lb,
+		cfg:  *cfg,
+		done: make(chan struct{}),
+	}
+	if ramp.cfg.MinWeightPercent <= 0 || ramp.cfg.MinWeightPercent > 100 {
+		ramp.cfg.MinWeightPercent = 10
+	}
+	go ramp.run()
+	return ramp
+}
+
+func (ramp *slowStart) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ramp.lb.rampUp()
+		case <-ramp.done:
+			return
+		}
+	}
+}
+
+func (ramp *slowStart) stop() {
+	ramp.stopOnce.Do(func() { close(ramp.done) })
+}
+
+// weight returns the effective weight of a host added at the given time
+func (ramp *slowStart) weight(added, now time.Time) int {
+	window := ramp.cfg.Window.Or(30 * time.Second)
+	elapsed := now.Sub(added)
+	if elapsed >= window {
+		return fullWeight
+	}
+	progress := float64(elapsed) / float64(window)
+	min := ramp.cfg.MinWeightPercent / 100
+	fraction := min + (1-min)*progress
+	if ramp.cfg.Mode == "exponential" {
+		fraction = min * math.Pow(1/min, progress)
+	}
+	if w := int(fraction * fullWeight); w > 1 {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/trusch/eve/config"
+	"github.com/vulcand/oxy/buffer"
+)
+
+// newTransport creates the transport a loadbalancer uses to talk to its hosts
+func newTransport(cfg *config.LoadbalancerConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialUnixSockets(newDialer(cfg).DialContext)
+	if t := cfg.Transport; t != nil {
+		transport.TLSHandshakeTimeout = t.TLSHandshakeTimeout.Or(transport.TLSHandshakeTimeout)
+		transport.ResponseHeaderTimeout = time.Duration(t.ResponseHeaderTimeout)
+		transport.IdleConnTimeout = t.IdleConnTimeout.Or(transport.IdleConnTimeout)
+		transport.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+		transport.MaxConnsPerHost = t.MaxConnsPerHost
+		if t.MaxIdleConnsPerHost > transport.MaxIdleConns {
+			transport.MaxIdleConns = 0
+		}
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+// newDialer creates the dialer for the connections to the hosts
+func newDialer(cfg *config.LoadbalancerConfig) *net.Dialer {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if t := cfg.Transport; t != nil {
+		dialer.Timeout = t.DialTimeout.Or(dialer.Timeout)
+		dialer.KeepAlive = t.KeepAlive.Or(dialer.KeepAlive)
+	}
+	return dialer
+}
+
+func newTLSConfig(cfg *config.UpstreamTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAPem != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.CAPem)) {
+			return nil, errors.New("no valid CA certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertPem != "" || cfg.KeyPem != "" {
+		cert, err := tls.X509KeyPair([]byte(cfg.CertPem), []byte(cfg.KeyPem))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newBuffer wraps next with request and response buffering
+func newBuffer(next http.Handler, cfg *config.BufferingConfig) (http.Handler, error) {
+	var opts []buffer.Option
+	if cfg.MaxRequestBodyBytes > 0 {
+		opts = append(opts, buffer.MaxRequestBodyBytes(cfg.MaxRequestBodyBytes))
+	}
+	if cfg.MemRequestBodyBytes > 0 {
+		opts = append(opts, buffer.MemRequestBodyBytes(cfg.MemRequestBodyBytes))
+	}
+	if cfg.MaxResponseBodyBytes > 0 {
+		opts = append(opts, buffer.MaxResponseBodyBytes(cfg.MaxResponseBodyBytes))
+	}
+	if cfg.MemResponseBodyBytes > 0 {
+		opts = append(opts, buffer.MemResponseBodyBytes(cfg.MemResponseBodyBytes))
+	}
+	return buffer.New(next, opts...)
+}
@@ -0,0 +1,42 @@
+package manager
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// unixHostSuffix marks the synthetic hosts which stand for unix sockets.
+// Every socket gets its own host, so the transport keeps separate connection pools.
+const unixHostSuffix = ".unix-socket"
+
+// unixSockets lets the forwarder talk HTTP to unix:// hosts
+// by replacing their URL with a synthetic host the transport dials as unix socket.
+type unixSockets struct {
+	next http.Handler
+}
+
+func (h *unixSockets) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Scheme == "unix" {
+		req.URL = &url.URL{Scheme: "http", Host: hex.EncodeToString([]byte(req.URL.Path)) + unixHostSuffix}
+	}
+	h.next.ServeHTTP(w, req)
+}
+
+// dialUnixSockets wraps dial so that synthetic unix socket hosts are dialed as unix sockets
+func dialUnixSockets(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil && strings.HasSuffix(host, unixHostSuffix) {
+			path, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix))
+			if err != nil {
+				return nil, err
+			}
+			return dial(ctx, "unix", string(path))
+		}
+		return dial(ctx, network, addr)
+	}
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/trusch/eve/metrics"
+	"github.com/trusch/eve/requestinfo"
+	"github.com/trusch/eve/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// upstream sits between the roundrobin and the forwarder.
+// At this point the host is chosen, so it can record per host information.
+type upstream struct {
+	lb   *loadbalancer
+	next http.Handler
+	// passHost keeps the client's Host header by default, i.e. for FastCGI
+	passHost bool
+}
+
+// hostHeader returns the Host header for the chosen host.
+// By default it is the host of the upstream URL, rules can rewrite it or pass the client's one.
+func (u *upstream) hostHeader(req *http.Request) string {
+	if r := requestinfo.FromRequest(req).Rule; r != nil && r.Rewrite != nil {
+		if r.Rewrite.Host != "" {
+			return r.Rewrite.Host
+		}
+		if r.Rewrite.PassHost {
+			return req.Host
+		}
+	}
+	switch {
+	case u.passHost:
+		return req.Host
+	case req.URL.Scheme == "unix":
+		return "localhost"
+	}
+	return req.URL.Host
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if a := attemptFromContext(req.Context()); a != nil {
+		if a.excluded[req.URL.String()] {
+			if alt := u.lb.alternative(a.excluded); alt != nil {
+				req.URL = alt
+			}
+		}
+		a.host = req.URL.String()
+	}
+	req.Host = u.hostHeader(req)
+	key := req.URL.String()
+	host := hostLabel(req.URL)
+	info := requestinfo.FromRequest(req)
+	info.Upstream = host
+	metrics.UpstreamStarted(u.lb.id, host)
+	defer metrics.UpstreamFinished(u.lb.id, host)
+	ctx, span := tracing.Start(req.Context(), "forward", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String("eve.loadbalancer", u.lb.id),
+		attribute.String("server.address", host),
+	)
+	defer span.End()
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req)
+	rw := requestinfo.NewResponseWriter(w)
+	start := time.Now()
+	u.next.ServeHTTP(rw, req)
+	info.UpstreamDuration = time.Since(start)
+	span.SetAttributes(attribute.Int("http.response.status_code", rw.StatusCode()))
+	if rw.StatusCode() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(rw.StatusCode()))
+	}
+	metrics.ObserveUpstream(u.lb.id, host, rw.StatusCode(), info.UpstreamDuration)
+	u.lb.observe(key, rw.StatusCode(), info.UpstreamDuration)
+}
@@ -3,6 +3,8 @@ package rule
 import (
 	"errors"
 	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/vulcand/route"
 )
@@ -12,22 +14,47 @@ type Rule struct {
 	ID     string
 	Route  string
 	Target string
+	// Split distributes the requests between several loadbalancers by weight. If set, Target is ignored.
+	Split []*SplitTarget `json:",omitempty"`
+	// Sticky is the name of a cookie which keeps clients on the split target they got first
+	Sticky string `json:",omitempty"`
+	// Override forces a split target by the value of a header or cookie
+	Override *Override `json:",omitempty"`
+	// Mirror copies matching requests to a shadow loadbalancer
+	Mirror *Mirror `json:",omitempty"`
+	// Rewrite changes the requests before they are forwarded
+	Rewrite *Rewrite `json:",omitempty"`
+	// SampleRate overrides the default trace sample rate for matching requests
+	SampleRate *float64 `json:",omitempty"`
 }
 
 // A Set is a set of rules which match requests to loadbalancers
 type Set struct {
+	mutex  sync.RWMutex
 	rules  map[string]*Rule
 	router route.Router
 }
 
 // UpsertRule upserts a rule
 func (rs *Set) UpsertRule(rule *Rule) error {
+	if rule.Rewrite != nil {
+		if err := rule.Rewrite.compile(); err != nil {
+			return err
+		}
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if old, ok := rs.rules[rule.ID]; ok && old.Route != rule.Route {
+		rs.router.RemoveRoute(old.Route)
+	}
 	rs.rules[rule.ID] = rule
-	return rs.router.UpsertRoute(rule.Route, rule.Target)
+	return rs.router.UpsertRoute(rule.Route, rule)
 }
 
 // RemoveRule removes a rule
 func (rs *Set) RemoveRule(id string) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
 	rule, ok := rs.rules[id]
 	if !ok {
 		return errors.New("rule not found")
@@ -36,21 +63,35 @@ func (rs *Set) RemoveRule(id string) error {
 	return rs.router.RemoveRoute(rule.Route)
 }
 
-// GetTarget returns the target loadbalancer ID for a request
-func (rs *Set) GetTarget(req *http.Request) (string, error) {
+// GetRule returns the rule matching a request
+func (rs *Set) GetRule(req *http.Request) (*Rule, error) {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
 	target, err := rs.router.Route(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if target == nil {
-		return "", errors.New("no matching loadbalancer rule")
+		return nil, errors.New("no matching loadbalancer rule")
+	}
+	return target.(*Rule), nil
+}
+
+// Rules returns all rules of the set sorted by ID
+func (rs *Set) Rules() []*Rule {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	rules := make([]*Rule, 0, len(rs.rules))
+	for _, rule := range rs.rules {
+		rules = append(rules, rule)
 	}
-	return target.(string), nil
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
 }
 
 // New returns a new rule object
 func New(id, route, target string) *Rule {
-	return &Rule{id, route, target}
+	return &Rule{ID: id, Route: route, Target: target}
 }
 
 // NewSet returns a new, empty rule set
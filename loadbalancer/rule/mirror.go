@@ -0,0 +1,12 @@
+package rule
+
+// Mirror asynchronously copies requests to a shadow loadbalancer and discards its responses
+type Mirror struct {
+	// Target is the shadow loadbalancer
+	Target string
+	// Percent is the percentage of requests which are mirrored (default 100), 0 turns mirroring off
+	Percent *float64 `json:",omitempty"`
+	// MaxBodyBytes is the maximum size of a mirrored request body (default 64KiB).
+	// Requests with bigger bodies are not mirrored.
+	MaxBodyBytes int64 `json:",omitempty"`
+}
@@ -0,0 +1,88 @@
+package rule
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Rewrite changes the path, the query and the Host header of requests before they are forwarded.
+// The path is first stripped, then regex replaced and then prefixed.
+type Rewrite struct {
+	// StripPrefix is removed from paths which start with it as a whole segment, i.e. /api strips /api/users but not /apis
+	StripPrefix string `json:",omitempty"`
+	AddPrefix   string `json:",omitempty"`
+	// Regex is replaced with Replacement in the path, Replacement can contain $1 style references
+	Regex       string `json:",omitempty"`
+	Replacement string `json:",omitempty"`
+	// SetQuery, AddQuery and RemoveQuery edit the query parameters
+	SetQuery    map[string]string `json:",omitempty"`
+	AddQuery    map[string]string `json:",omitempty"`
+	RemoveQuery []string          `json:",omitempty"`
+	// Host replaces the Host header
+	Host string `json:",omitempty"`
+	// PassHost passes the Host header of the client instead of the host of the upstream URL
+	PassHost bool `json:",omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// compile prepares the regex of the rewrite
+func (rw *Rewrite) compile() error {
+	if rw.Regex == "" {
+		return nil
+	}
+	regex, err := regexp.Compile(rw.Regex)
+	if err != nil {
+		return err
+	}
+	rw.regex = regex
+	return nil
+}
+
+// Apply returns the rewritten request. The original request stays untouched.
+// It fails if the regex of the rewrite hasn't been compiled, i.e. because the rule wasn't added to a Set.
+func (rw *Rewrite) Apply(req *http.Request) (*http.Request, error) {
+	if rw.Regex != "" && rw.regex == nil {
+		return nil, errors.New("rewrite regex is not compiled")
+	}
+	u := *req.URL
+	p := u.Path
+	if rw.StripPrefix != "" {
+		prefix := strings.TrimSuffix(rw.StripPrefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			p = strings.TrimPrefix(p, prefix)
+			if !strings.HasPrefix(p, "/") {
+				p = "/" + p
+			}
+		}
+	}
+	if rw.regex != nil {
+		p = rw.regex.ReplaceAllString(p, rw.Replacement)
+	}
+	if rw.AddPrefix != "" {
+		p = strings.TrimSuffix(rw.AddPrefix, "/") + p
+	}
+	// an untouched path keeps its original encoding
+	if p != u.Path {
+		u.Path, u.RawPath = p, ""
+	}
+	if len(rw.SetQuery) > 0 || len(rw.AddQuery) > 0 || len(rw.RemoveQuery) > 0 {
+		query := u.Query()
+		for _, key := range rw.RemoveQuery {
+			query.Del(key)
+		}
+		for key, value := range rw.SetQuery {
+			query.Set(key, value)
+		}
+		for key, value := range rw.AddQuery {
+			query.Add(key, value)
+		}
+		u.RawQuery = query.Encode()
+	}
+	rewritten := req.WithContext(req.Context())
+	rewritten.URL = &u
+	rewritten.RequestURI = u.RequestURI()
+	return rewritten, nil
+}
@@ -0,0 +1,78 @@
+package rule
+
+import (
+	"math/rand"
+	"net/http"
+)
+
+// SplitTarget is a loadbalancer which gets a weighted share of the requests of a rule
+type SplitTarget struct {
+	Target string
+	Weight int
+}
+
+// Override names the header or cookie whose value forces a split target, i.e. for testers
+type Override struct {
+	Header string `json:",omitempty"`
+	Cookie string `json:",omitempty"`
+}
+
+// Choose returns the loadbalancer a request should be sent to and whether it was chosen by weight.
+// Overrides win over the sticky cookie, which wins over the weighted choice.
+// Only targets with a weight > 0 can be chosen by cookie, so setting a weight to 0 moves all clients away.
+func (rule *Rule) Choose(req *http.Request) (string, bool) {
+	if len(rule.Split) == 0 {
+		return rule.Target, false
+	}
+	if rule.Override != nil {
+		if rule.Override.Header != "" {
+			if target := rule.splitTarget(req.Header.Get(rule.Override.Header), false); target != "" {
+				return target, false
+			}
+		}
+		if rule.Override.Cookie != "" {
+			if cookie, err := req.Cookie(rule.Override.Cookie); err == nil {
+				if target := rule.splitTarget(cookie.Value, false); target != "" {
+					return target, false
+				}
+			}
+		}
+	}
+	if rule.Sticky != "" {
+		if cookie, err := req.Cookie(rule.Sticky); err == nil {
+			if target := rule.splitTarget(cookie.Value, true); target != "" {
+				return target, false
+			}
+		}
+	}
+	total := 0
+	for _, split := range rule.Split {
+		if split.Weight > 0 {
+			total += split.Weight
+		}
+	}
+	if total == 0 {
+		return rule.Split[0].Target, true
+	}
+	n := rand.Intn(total)
+	for _, split := range rule.Split {
+		if split.Weight <= 0 {
+			continue
+		}
+		if n < split.Weight {
+			return split.Target, true
+		}
+		n -= split.Weight
+	}
+	return rule.Split[len(rule.Split)-1].Target, true
+}
+
+// splitTarget returns target if it is part of the split
+func (rule *Rule) splitTarget(target string, weighted bool) string {
+	for _, split := range rule.Split {
+		if split.Target == target && (!weighted || split.Weight > 0) {
+			return target
+		}
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Level is the global log level. It can be changed at runtime.
+var Level = new(slog.LevelVar)
+
+// New returns a logger writing text or json lines to w with the global log level
+func New(w io.Writer, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: Level}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format '%v'", format)
+}
+
+// SetLevel parses and sets the global log level (debug, info, warn or error)
+func SetLevel(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return err
+	}
+	Level.Set(l)
+	return nil
+}
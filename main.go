@@ -21,13 +21,10 @@
 package main
 
 import (
-	"log"
-
 	"github.com/trusch/eve/cmd"
 	_ "github.com/trusch/eve/middleware/builtin"
 )
 
 func main() {
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
 	cmd.Execute()
 }
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// All label values are taken from the configuration (rule IDs, loadbalancer IDs, host URLs)
+// and never from the request itself, so the cardinality stays bounded by the configured rules.
+var (
+	// RequestsInFlight counts the requests currently handled by eve
+	RequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "eve_requests_in_flight",
+		Help: "Number of requests currently being served.",
+	})
+	// Requests counts handled requests
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_requests_total",
+		Help: "Total number of handled requests.",
+	}, []string{"rule", "loadbalancer", "code"})
+	// RequestDuration observes the total request latency
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eve_request_duration_seconds",
+		Help:    "Total request latency.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"rule", "loadbalancer"})
+	// UpstreamRequestsInFlight counts the requests currently forwarded to a host
+	UpstreamRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eve_upstream_requests_in_flight",
+		Help: "Number of requests currently forwarded to an upstream host.",
+	}, []string{"loadbalancer", "host"})
+	// UpstreamRequests counts requests forwarded to a host
+	UpstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_upstream_requests_total",
+		Help: "Total number of requests forwarded to an upstream host.",
+	}, []string{"loadbalancer", "host", "code"})
+	// UpstreamRequestDuration observes the latency of upstream hosts
+	UpstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eve_upstream_request_duration_seconds",
+		Help:    "Latency of upstream hosts.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"loadbalancer", "host"})
+	// UpstreamEjections counts the ejections of upstream hosts by the outlier detection
+	UpstreamEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_upstream_ejections_total",
+		Help: "Total number of upstream host ejections by the outlier detection.",
+	}, []string{"loadbalancer", "host"})
+	// UpstreamRetries counts the retries of failed upstream requests
+	UpstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_upstream_retries_total",
+		Help: "Total number of retried upstream requests.",
+	}, []string{"loadbalancer"})
+	// CircuitBreakerOpen reports whether the circuit breaker of a loadbalancer is open
+	CircuitBreakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eve_circuit_breaker_open",
+		Help: "Whether the circuit breaker of a loadbalancer is open (1) or closed (0).",
+	}, []string{"loadbalancer"})
+	// ActiveTier reports the priority tier a loadbalancer currently sends traffic to
+	ActiveTier = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eve_loadbalancer_active_tier",
+		Help: "The priority tier a loadbalancer currently sends traffic to.",
+	}, []string{"loadbalancer"})
+	// MirrorRequests counts the requests mirrored to shadow loadbalancers by result
+	MirrorRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_mirror_requests_total",
+		Help: "Total number of mirrored requests by result (ok, error, dropped, too_large, incomplete).",
+	}, []string{"rule", "loadbalancer", "result"})
+	// MirrorRequestDuration observes the latency of mirrored requests
+	MirrorRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eve_mirror_request_duration_seconds",
+		Help:    "Latency of mirrored requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"rule", "loadbalancer"})
+	// ConfigActions counts the config actions per source and type
+	ConfigActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_config_actions_total",
+		Help: "Total number of applied config actions.",
+	}, []string{"source", "type"})
+	// TLSHandshakeErrors counts failed TLS handshakes
+	TLSHandshakeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "eve_tls_handshake_errors_total",
+		Help: "Total number of failed TLS handshakes.",
+	})
+	// AccessLogErrors counts access log entries which couldn't be written
+	AccessLogErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eve_access_log_errors_total",
+		Help: "Total number of access log entries which couldn't be written.",
+	}, []string{"rule"})
+	// CertificateExpiry exposes the expiry date of the loaded certificates
+	CertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eve_certificate_expiry_timestamp_seconds",
+		Help: "Expiry date of a loaded certificate as unix timestamp.",
+	}, []string{"cert"})
+	// EtcdWatchUp reports whether an etcd watch is established
+	EtcdWatchUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eve_etcd_watch_up",
+		Help: "Whether the etcd watch on a key prefix is established (1) or not (0).",
+	}, []string{"prefix"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		RequestsInFlight,
+		Requests,
+		RequestDuration,
+		UpstreamRequestsInFlight,
+		UpstreamRequests,
+		UpstreamRequestDuration,
+		UpstreamEjections,
+		UpstreamRetries,
+		CircuitBreakerOpen,
+		ActiveTier,
+		MirrorRequests,
+		MirrorRequestDuration,
+		ConfigActions,
+		TLSHandshakeErrors,
+		AccessLogErrors,
+		CertificateExpiry,
+		EtcdWatchUp,
+	)
+}
+
+// ObserveRequest records a finished request
+func ObserveRequest(info *requestinfo.Info) {
+	code := strconv.Itoa(info.Status)
+	Requests.WithLabelValues(info.RuleID, info.Loadbalancer, code).Inc()
+	RequestDuration.WithLabelValues(info.RuleID, info.Loadbalancer).Observe(time.Since(info.Start).Seconds())
+}
+
+// ObserveUpstream records a finished upstream request
+func ObserveUpstream(loadbalancer, host string, status int, duration time.Duration) {
+	UpstreamRequests.WithLabelValues(loadbalancer, host, strconv.Itoa(status)).Inc()
+	UpstreamRequestDuration.WithLabelValues(loadbalancer, host).Observe(duration.Seconds())
+}
+
+// ForgetRule drops all series of a removed loadbalancer rule
+func ForgetRule(id string) {
+	Requests.DeletePartialMatch(prometheus.Labels{"rule": id})
+	RequestDuration.DeletePartialMatch(prometheus.Labels{"rule": id})
+	MirrorRequests.DeletePartialMatch(prometheus.Labels{"rule": id})
+	MirrorRequestDuration.DeletePartialMatch(prometheus.Labels{"rule": id})
+}
+
+// inFlight tracks the in-flight requests per host, so the gauge of a removed host
+// is only dropped after its last request finished. Otherwise that request would recreate it at -1.
+var inFlight = struct {
+	sync.Mutex
+	count     map[[2]string]int
+	forgotten map[[2]string]bool
+}{
+	count:     make(map[[2]string]int),
+	forgotten: make(map[[2]string]bool),
+}
+
+// UpstreamStarted records a request being forwarded to a host
+func UpstreamStarted(loadbalancer, host string) {
+	key := [2]string{loadbalancer, host}
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	inFlight.count[key]++
+	delete(inFlight.forgotten, key)
+	UpstreamRequestsInFlight.WithLabelValues(loadbalancer, host).Inc()
+}
+
+// UpstreamFinished records the end of a request started with UpstreamStarted
+func UpstreamFinished(loadbalancer, host string) {
+	key := [2]string{loadbalancer, host}
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	inFlight.count[key]--
+	if inFlight.count[key] > 0 {
+		UpstreamRequestsInFlight.WithLabelValues(loadbalancer, host).Dec()
+		return
+	}
+	delete(inFlight.count, key)
+	if inFlight.forgotten[key] {
+		delete(inFlight.forgotten, key)
+		UpstreamRequestsInFlight.DeleteLabelValues(loadbalancer, host)
+		return
+	}
+	UpstreamRequestsInFlight.WithLabelValues(loadbalancer, host).Dec()
+}
+
+// ForgetHost drops all series of a removed upstream host.
+// The in-flight gauge is dropped once the running requests finished.
+func ForgetHost(loadbalancer, host string) {
+	labels := prometheus.Labels{"loadbalancer": loadbalancer, "host": host}
+	key := [2]string{loadbalancer, host}
+	inFlight.Lock()
+	if inFlight.count[key] > 0 {
+		inFlight.forgotten[key] = true
+	} else {
+		UpstreamRequestsInFlight.DeleteLabelValues(loadbalancer, host)
+	}
+	inFlight.Unlock()
+	UpstreamRequests.DeletePartialMatch(labels)
+	UpstreamRequestDuration.DeletePartialMatch(labels)
+	UpstreamEjections.DeletePartialMatch(labels)
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+)
+
+var handshakeError = []byte("TLS handshake error")
+
+type errorLogWriter struct {
+	logger *slog.Logger
+}
+
+// Write counts TLS handshake errors and passes every line on to the logger.
+// net/http reports handshake failures only through the servers error log.
+func (w errorLogWriter) Write(line []byte) (int, error) {
+	msg := string(bytes.TrimSpace(line))
+	if bytes.Contains(line, handshakeError) {
+		TLSHandshakeErrors.Inc()
+		w.logger.Debug(msg)
+	} else {
+		w.logger.Warn(msg)
+	}
+	return len(line), nil
+}
+
+// NewErrorLog returns a logger for http.Server.ErrorLog which counts TLS handshake errors
+func NewErrorLog(logger *slog.Logger) *log.Logger {
+	return log.New(errorLogWriter{logger}, "", 0)
+}
@@ -0,0 +1,54 @@
+package builtin
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/trusch/eve/accesslog"
+	"github.com/trusch/eve/metrics"
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+	"github.com/trusch/eve/requestinfo"
+)
+
+func accessLogConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &accesslog.Config{}
+	err := mapstructure.Decode(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Output == "" {
+		opts.Output = "stdout"
+	}
+	logger, err := accesslog.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{next, logger}, nil
+}
+
+type accessLog struct {
+	next   http.Handler
+	logger *accesslog.Logger
+}
+
+func (mw *accessLog) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rw := requestinfo.NewResponseWriter(w)
+	mw.next.ServeHTTP(rw, req)
+	info := *requestinfo.FromRequest(req)
+	info.Status = rw.StatusCode()
+	info.Bytes = rw.Bytes
+	if err := mw.logger.Log(req, &info); err != nil {
+		metrics.AccessLogErrors.WithLabelValues(info.RuleID).Inc()
+		slog.Warn("failed to write access log", "rule", info.RuleID, "error", err)
+	}
+}
+
+func (mw *accessLog) Close() error {
+	return mw.logger.Close()
+}
+
+func init() {
+	registry.Register("accesslog", accessLogConstructor)
+}
@@ -0,0 +1,162 @@
+package builtin
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/kv"
+	"github.com/trusch/eve/middleware/registry"
+)
+
+// basicAuthOpts are the options of the basicauth middleware
+type basicAuthOpts struct {
+	// Realm is shown by the browser (default eve)
+	Realm string
+	// Users are htpasswd entries (user:hash) with bcrypt, argon2, apr1 or {SHA} hashes
+	Users []string
+	// UsersKey is an etcd key holding htpasswd entries. Changes are applied immediately.
+	UsersKey string
+	// HeaderField passes the authenticated user upstream in this header
+	HeaderField string
+	// RemoveHeader strips the Authorization header before forwarding
+	RemoveHeader bool
+}
+
+func basicAuthConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &basicAuthOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if opts.Realm == "" {
+		opts.Realm = "eve"
+	}
+	if len(opts.Users) == 0 && opts.UsersKey == "" {
+		return nil, errors.New("basicauth: specify Users or UsersKey")
+	}
+	mw := &basicAuth{next: next, opts: opts}
+	inline, err := parseHtpasswd(opts.Users)
+	if err != nil {
+		return nil, err
+	}
+	mw.setUsers(inline, nil)
+	if opts.UsersKey != "" {
+		store, err := kv.Get()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		mw.cancel = cancel
+		value, _, err := store.Get(ctx, opts.UsersKey)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if err := mw.loadUsers(inline, value); err != nil {
+			cancel()
+			return nil, err
+		}
+		go func() {
+			for value := range store.Watch(ctx, opts.UsersKey) {
+				// keep the old users if the new ones are malformed
+				mw.loadUsers(inline, value)
+			}
+		}()
+	}
+	return mw, nil
+}
+
+type basicAuth struct {
+	next   http.Handler
+	opts   *basicAuthOpts
+	cancel context.CancelFunc
+
+	mutex sync.RWMutex
+	users map[string]string
+	// verified caches successful verifications, the hashes are slow by design
+	verified map[[sha256.Size]byte]bool
+}
+
+func (mw *basicAuth) loadUsers(inline map[string]string, value string) error {
+	stored, err := parseHtpasswd([]string{value})
+	if err != nil {
+		return err
+	}
+	mw.setUsers(inline, stored)
+	return nil
+}
+
+func (mw *basicAuth) setUsers(maps ...map[string]string) {
+	users := make(map[string]string)
+	for _, m := range maps {
+		for user, hash := range m {
+			users[user] = hash
+		}
+	}
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+	mw.users = users
+	mw.verified = make(map[[sha256.Size]byte]bool)
+}
+
+func (mw *basicAuth) authenticate(user, password string) bool {
+	mw.mutex.RLock()
+	hash, ok := mw.users[user]
+	mw.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(hash + "\x00" + password))
+	mw.mutex.RLock()
+	cached := mw.verified[key]
+	mw.mutex.RUnlock()
+	if cached {
+		return true
+	}
+	if !verifyPassword(hash, password) {
+		return false
+	}
+	mw.mutex.Lock()
+	if len(mw.verified) > 1024 {
+		mw.verified = make(map[[sha256.Size]byte]bool)
+	}
+	mw.verified[key] = true
+	mw.mutex.Unlock()
+	return true
+}
+
+func (mw *basicAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	user, password, ok := req.BasicAuth()
+	if !ok || !mw.authenticate(user, password) {
+		w.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(mw.opts.Realm))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if mw.opts.HeaderField != "" || mw.opts.RemoveHeader {
+		req = req.WithContext(req.Context())
+		req.Header = req.Header.Clone()
+		if mw.opts.HeaderField != "" {
+			req.Header.Set(mw.opts.HeaderField, user)
+		}
+		if mw.opts.RemoveHeader {
+			req.Header.Del("Authorization")
+		}
+	}
+	mw.next.ServeHTTP(w, req)
+}
+
+func (mw *basicAuth) Close() error {
+	if mw.cancel != nil {
+		mw.cancel()
+	}
+	return nil
+}
+
+func init() {
+	registry.Register("basicauth", basicAuthConstructor)
+	registry.RegisterSecrets("basicauth", "Users")
+}
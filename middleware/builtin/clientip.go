@@ -0,0 +1,47 @@
+package builtin
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/trusch/eve/requestinfo"
+)
+
+// clientIP returns the IP of the client which sent the request.
+// It is the peer address unless the ipfilter middleware resolved a client behind trusted proxies.
+func clientIP(req *http.Request) string {
+	if ip := requestinfo.FromRequest(req).ClientIP; ip != "" {
+		return ip
+	}
+	return peerIP(req)
+}
+
+// peerIP returns the IP of the peer which sent the request
+func peerIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// forwardedIP returns the client IP behind depth trusted proxies, which append to X-Forwarded-For.
+// With fewer entries the request didn't pass all proxies and the entries were written by the client,
+// so the peer address is used.
+func forwardedIP(req *http.Request, depth int) string {
+	addrs := []string{}
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	addrs = append(addrs, peerIP(req))
+	i := len(addrs) - 1 - depth
+	if i < 0 {
+		return peerIP(req)
+	}
+	return addrs[i]
+}
@@ -0,0 +1,297 @@
+package builtin
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+)
+
+// compressOpts are the options of the compress middleware
+type compressOpts struct {
+	// Encodings are the offered encodings in order of preference (default zstd, br, gzip)
+	Encodings []string
+	// MinSize is the size below which responses are sent uncompressed (default 1024)
+	MinSize int
+	// ContentTypes are the compressed media types, * matches any part
+	// (default text/*, application/json, application/*+json, application/javascript,
+	// application/xml, application/*+xml, application/wasm and image/svg+xml)
+	ContentTypes []string
+}
+
+// encoder is implemented by the writers of all supported encodings
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools reuse encoders, their allocation is expensive
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}},
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, 5)
+	}},
+	"zstd": {New: func() interface{} {
+		// browsers accept windows up to 8MB
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithWindowSize(8<<20), zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+func compressConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &compressOpts{MinSize: 1024}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{"zstd", "br", "gzip"}
+	}
+	for _, encoding := range opts.Encodings {
+		if encoderPools[encoding] == nil {
+			return nil, fmt.Errorf("compress: unsupported encoding '%v'", encoding)
+		}
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = []string{
+			"text/*",
+			"application/json", "application/*+json",
+			"application/javascript",
+			"application/xml", "application/*+xml",
+			"application/wasm",
+			"image/svg+xml",
+		}
+	}
+	return &compress{next: next, opts: opts}, nil
+}
+
+type compress struct {
+	next http.Handler
+	opts *compressOpts
+}
+
+func (mw *compress) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"), mw.opts.Encodings)
+	// upgraded connections and partial content are passed through untouched
+	if encoding == "" || req.Header.Get("Upgrade") != "" || req.Header.Get("Range") != "" || req.Method == http.MethodHead {
+		mw.next.ServeHTTP(w, req)
+		return
+	}
+	cw := &compressWriter{ResponseWriter: w, mw: mw, encoding: encoding}
+	defer cw.close()
+	mw.next.ServeHTTP(cw, req)
+}
+
+// compressible reports whether responses of the given content type are compressed
+func (mw *compress) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	// events must reach the client immediately
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	for _, pattern := range mw.opts.ContentTypes {
+		prefix, suffix, wildcard := strings.Cut(pattern, "*")
+		if !wildcard && mediaType == pattern {
+			return true
+		}
+		if wildcard && len(mediaType) >= len(prefix)+len(suffix) &&
+			strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the beginning of a response until it knows whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	mw       *compress
+	encoding string
+	status   int
+	decided  bool
+	hijacked bool
+	encoder  encoder
+	buf      []byte
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 || w.hijacked {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	header := w.Header()
+	switch {
+	case status < 200, status == http.StatusNoContent, status == http.StatusNotModified, status == http.StatusPartialContent,
+		header.Get("Content-Encoding") != "",
+		strings.Contains(header.Get("Cache-Control"), "no-transform"),
+		header.Get("Content-Type") != "" && !w.mw.compressible(header.Get("Content-Type")):
+		w.start(false)
+	default:
+		if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && header.Get("Content-Type") != "" {
+			w.start(length >= w.mw.opts.MinSize)
+		}
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.mw.opts.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush sends what was written so far. Flushed responses are streams of unknown size, so they are compressed
+// if their type allows it.
+func (w *compressWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide starts the response with the buffered data, compressed if wanted and the content type allows it
+func (w *compressWriter) decide(compress bool) error {
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	w.start(compress && w.mw.compressible(header.Get("Content-Type")))
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// start writes the header, setting up the encoder if compress is set
+func (w *compressWriter) start(compress bool) {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Encoding") == "" && w.mw.compressible(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// the compressed representation differs byte by byte
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.encoder = encoderPools[w.encoding].Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close finishes the response after the handler returned
+func (w *compressWriter) close() {
+	if w.hijacked || w.status == 0 {
+		return
+	}
+	if !w.decided {
+		// the whole response is smaller than MinSize
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		encoderPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+// negotiateEncoding returns the offered encoding with the highest quality in the Accept-Encoding header.
+// Ties are resolved by the order of offered.
+func negotiateEncoding(acceptEncoding string, offered []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range offered {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func init() {
+	registry.Register("compress", compressConstructor)
+}
@@ -0,0 +1,213 @@
+package builtin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+)
+
+// corsOpts are the options of the cors middleware
+type corsOpts struct {
+	// AllowedOrigins are exact origins like https://app.example.com,
+	// wildcard subdomains like https://*.example.com or * for any origin
+	AllowedOrigins []string
+	// AllowedOriginRegexps are regular expressions matching the whole origin
+	AllowedOriginRegexps []string
+	// AllowedMethods (default GET, HEAD, POST)
+	AllowedMethods []string
+	// AllowedHeaders are the request headers clients may send, * allows any
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and authorization headers
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results
+	MaxAge time.Duration
+}
+
+func corsConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &corsOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	mw := &cors{
+		next:    next,
+		opts:    opts,
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+		origins: make(map[string]bool),
+	}
+	for _, method := range opts.AllowedMethods {
+		mw.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range opts.AllowedHeaders {
+		if header == "*" {
+			mw.anyHeader = true
+		}
+		mw.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	for _, origin := range opts.AllowedOrigins {
+		switch {
+		case origin == "*":
+			mw.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			// https://*.example.com matches https://a.example.com and https://a.b.example.com
+			scheme, domain, _ := strings.Cut(origin, "://*")
+			mw.wildcards = append(mw.wildcards, [2]string{strings.ToLower(scheme) + "://", strings.ToLower(domain)})
+		default:
+			mw.origins[strings.ToLower(origin)] = true
+		}
+	}
+	// browsers ignore credentialed responses allowing any origin, echoing every origin instead would expose
+	// the users' data to any site
+	if mw.anyOrigin && opts.AllowCredentials {
+		return nil, errors.New("cors: AllowedOrigins * can't be combined with AllowCredentials")
+	}
+	for _, expr := range opts.AllowedOriginRegexps {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("cors: %v", err)
+		}
+		mw.regexps = append(mw.regexps, re)
+	}
+	return mw, nil
+}
+
+type cors struct {
+	next      http.Handler
+	opts      *corsOpts
+	methods   map[string]bool
+	headers   map[string]bool
+	anyHeader bool
+	origins   map[string]bool
+	anyOrigin bool
+	wildcards [][2]string
+	regexps   []*regexp.Regexp
+}
+
+func (mw *cors) originAllowed(origin string) bool {
+	if mw.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if mw.origins[origin] {
+		return true
+	}
+	for _, wildcard := range mw.wildcards {
+		if strings.HasPrefix(origin, wildcard[0]) && strings.HasSuffix(origin, wildcard[1]) &&
+			len(origin) > len(wildcard[0])+len(wildcard[1]) {
+			return true
+		}
+	}
+	for _, re := range mw.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets the allowed origin. The response depends on the Origin header,
+// unless any origin is allowed.
+func (mw *cors) allowOrigin(header http.Header, origin string) {
+	if mw.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if mw.opts.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (mw *cors) varyOrigin(header http.Header) {
+	if !mw.anyOrigin {
+		header.Add("Vary", "Origin")
+	}
+}
+
+func (mw *cors) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if req.Method == http.MethodOptions && origin != "" && req.Header.Get("Access-Control-Request-Method") != "" {
+		mw.preflight(w, req, origin)
+		return
+	}
+	w = &hookWriter{ResponseWriter: w, hook: func(w http.ResponseWriter, status int) {
+		header := w.Header()
+		// upstream CORS headers would conflict with ours
+		for name := range header {
+			if strings.HasPrefix(name, "Access-Control-") {
+				header.Del(name)
+			}
+		}
+		mw.varyOrigin(header)
+		if origin == "" || !mw.originAllowed(origin) {
+			return
+		}
+		mw.allowOrigin(header, origin)
+		if len(mw.opts.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(mw.opts.ExposedHeaders, ", "))
+		}
+	}}
+	mw.next.ServeHTTP(w, req)
+}
+
+// preflight answers preflight requests, they aren't forwarded.
+// Disallowed requests get no CORS headers, so the browser blocks the actual request.
+func (mw *cors) preflight(w http.ResponseWriter, req *http.Request, origin string) {
+	header := w.Header()
+	mw.varyOrigin(header)
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	requested := parseHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+	if !mw.originAllowed(origin) || !mw.methods[method] || !mw.headersAllowed(requested) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	mw.allowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(mw.opts.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if mw.opts.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(mw.opts.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (mw *cors) headersAllowed(requested []string) bool {
+	if mw.anyHeader {
+		return true
+	}
+	for _, header := range requested {
+		if !mw.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHeaderList(value string) []string {
+	headers := []string{}
+	for _, header := range strings.Split(value, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+func init() {
+	registry.Register("cors", corsConstructor)
+}
@@ -0,0 +1,261 @@
+package builtin
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+)
+
+// maxAuthCacheEntries bounds the memory used by the forwardauth cache
+const maxAuthCacheEntries = 10000
+
+// maxAuthBodyBytes is the size of denial bodies which are passed to the client
+const maxAuthBodyBytes = 64 << 10
+
+// forwardAuthOpts are the options of the forwardauth middleware
+type forwardAuthOpts struct {
+	// URL of the auth service
+	URL string
+	// RequestHeaders are copied to the subrequest (default Authorization, Cookie)
+	RequestHeaders []string
+	// ResponseHeaders are copied from an accepting auth response to the upstream request
+	ResponseHeaders []string
+	// Timeout of the subrequest (default 5s)
+	Timeout time.Duration
+	// CacheTTL caches accepting responses, 0 disables the cache
+	CacheTTL time.Duration
+	// CacheKey are the inputs identifying cached responses: method, uri, host, ip,
+	// header:<name> and cookie:<name> (default method, uri and the RequestHeaders)
+	CacheKey []string
+}
+
+func forwardAuthConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &forwardAuthOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if opts.URL == "" {
+		return nil, errors.New("forwardauth: specify URL")
+	}
+	if opts.RequestHeaders == nil {
+		opts.RequestHeaders = []string{"Authorization", "Cookie"}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if len(opts.CacheKey) == 0 {
+		opts.CacheKey = []string{"method", "uri"}
+		for _, header := range opts.RequestHeaders {
+			opts.CacheKey = append(opts.CacheKey, "header:"+header)
+		}
+	}
+	for _, input := range opts.CacheKey {
+		switch {
+		case input == "method", input == "uri", input == "host", input == "ip":
+		case strings.HasPrefix(input, "header:"), strings.HasPrefix(input, "cookie:"):
+		default:
+			return nil, errors.New("forwardauth: unknown cache key input '" + input + "'")
+		}
+	}
+	return &forwardAuth{
+		next: next,
+		opts: opts,
+		client: &http.Client{
+			// redirects of the auth service are meant for the client
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cache: make(map[[sha256.Size]byte]authCacheEntry),
+	}, nil
+}
+
+type forwardAuth struct {
+	next   http.Handler
+	opts   *forwardAuthOpts
+	client *http.Client
+
+	mutex sync.Mutex
+	cache map[[sha256.Size]byte]authCacheEntry
+}
+
+type authCacheEntry struct {
+	headers http.Header
+	expires time.Time
+}
+
+func (mw *forwardAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var key [sha256.Size]byte
+	headers, cached := http.Header(nil), false
+	if mw.opts.CacheTTL > 0 {
+		key = mw.cacheKey(req)
+		headers, cached = mw.cached(key)
+	}
+	if !cached {
+		resp, err := mw.subrequest(req)
+		if err != nil {
+			slog.Warn("forwardauth request failed", "url", mw.opts.URL, "error", err)
+			status := http.StatusBadGateway
+			if errors.Is(err, context.DeadlineExceeded) {
+				status = http.StatusGatewayTimeout
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			deny(w, resp)
+			return
+		}
+		headers = make(http.Header)
+		for _, name := range mw.opts.ResponseHeaders {
+			if values := resp.Header.Values(name); len(values) > 0 {
+				headers[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		if mw.opts.CacheTTL > 0 {
+			mw.store(key, headers)
+		}
+	}
+	if len(mw.opts.ResponseHeaders) > 0 {
+		req = req.WithContext(req.Context())
+		req.Header = req.Header.Clone()
+		for _, name := range mw.opts.ResponseHeaders {
+			// never pass client supplied values for these headers
+			req.Header.Del(name)
+		}
+		for name, values := range headers {
+			// cached values are shared, later handlers may modify the header
+			req.Header[name] = append([]string(nil), values...)
+		}
+	}
+	mw.next.ServeHTTP(w, req)
+}
+
+// subrequest asks the auth service about req
+func (mw *forwardAuth) subrequest(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), mw.opts.Timeout)
+	sub, err := http.NewRequestWithContext(ctx, req.Method, mw.opts.URL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for _, name := range mw.opts.RequestHeaders {
+		for _, value := range req.Header.Values(name) {
+			sub.Header.Add(name, value)
+		}
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	forwardedFor := peerIP(req)
+	if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+		forwardedFor = prior + ", " + forwardedFor
+	}
+	sub.Header.Set("X-Forwarded-Method", req.Method)
+	sub.Header.Set("X-Forwarded-Proto", scheme)
+	sub.Header.Set("X-Forwarded-Host", req.Host)
+	sub.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+	sub.Header.Set("X-Forwarded-For", forwardedFor)
+	resp, err := mw.client.Do(sub)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// deny passes the response of the auth service to the client
+func deny(w http.ResponseWriter, resp *http.Response) {
+	for name, values := range resp.Header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Connection", "Keep-Alive", "Transfer-Encoding", "Content-Length", "Upgrade":
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, io.LimitReader(resp.Body, maxAuthBodyBytes))
+}
+
+func (mw *forwardAuth) cacheKey(req *http.Request) [sha256.Size]byte {
+	hash := sha256.New()
+	for _, input := range mw.opts.CacheKey {
+		var value string
+		switch {
+		case input == "method":
+			value = req.Method
+		case input == "uri":
+			value = req.URL.RequestURI()
+		case input == "host":
+			value = req.Host
+		case input == "ip":
+			value = clientIP(req)
+		case strings.HasPrefix(input, "header:"):
+			value = strings.Join(req.Header.Values(strings.TrimPrefix(input, "header:")), "\n")
+		case strings.HasPrefix(input, "cookie:"):
+			if cookie, err := req.Cookie(strings.TrimPrefix(input, "cookie:")); err == nil {
+				value = cookie.Value
+			}
+		}
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key
+}
+
+func (mw *forwardAuth) cached(key [sha256.Size]byte) (http.Header, bool) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+	entry, ok := mw.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.headers, true
+}
+
+func (mw *forwardAuth) store(key [sha256.Size]byte, headers http.Header) {
+	now := time.Now()
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+	if len(mw.cache) >= maxAuthCacheEntries {
+		for k, entry := range mw.cache {
+			if now.After(entry.expires) {
+				delete(mw.cache, k)
+			}
+		}
+		if len(mw.cache) >= maxAuthCacheEntries {
+			mw.cache = make(map[[sha256.Size]byte]authCacheEntry)
+		}
+	}
+	mw.cache[key] = authCacheEntry{headers: headers, expires: now.Add(mw.opts.CacheTTL)}
+}
+
+// cancelBody releases the context of a subrequest when its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+func init() {
+	registry.Register("forwardauth", forwardAuthConstructor)
+}
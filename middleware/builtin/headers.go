@@ -0,0 +1,177 @@
+package builtin
+
+import (
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// headersOpts are the options of the headers middleware.
+// Values are templates, i.e. "{{.ClientIP}}", see headerVars for the variables.
+type headersOpts struct {
+	Request  headerOps
+	Response headerOps
+}
+
+// headerOps are applied in the order Remove, Rename, Set, Add
+type headerOps struct {
+	Set    map[string]string
+	Add    map[string]string
+	Remove []string
+	Rename map[string]string
+}
+
+// headerVars are the variables available in header templates
+type headerVars struct {
+	req          *http.Request
+	ClientIP     string
+	RuleID       string
+	Loadbalancer string
+	// Upstream is only known for response headers
+	Upstream  string
+	RequestID string
+	Host      string
+	Method    string
+	Path      string
+	Scheme    string
+}
+
+// Header returns a header of the request
+func (vars *headerVars) Header(name string) string {
+	return vars.req.Header.Get(name)
+}
+
+// Claim returns a claim verified by the jwt middleware
+func (vars *headerVars) Claim(name string) string {
+	if value, ok := requestinfo.FromRequest(vars.req).Claims[name]; ok {
+		return claimString(value)
+	}
+	return ""
+}
+
+func newHeaderVars(req *http.Request) *headerVars {
+	info := requestinfo.FromRequest(req)
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return &headerVars{
+		req:          req,
+		ClientIP:     clientIP(req),
+		RuleID:       info.RuleID,
+		Loadbalancer: info.Loadbalancer,
+		Upstream:     info.Upstream,
+		RequestID:    requestinfo.RequestID(req),
+		Host:         req.Host,
+		Method:       req.Method,
+		Path:         req.URL.Path,
+		Scheme:       scheme,
+	}
+}
+
+type compiledHeaderOps struct {
+	set    map[string]*template.Template
+	add    map[string]*template.Template
+	remove []string
+	rename map[string]string
+}
+
+func compileHeaderOps(ops headerOps) (*compiledHeaderOps, error) {
+	compiled := &compiledHeaderOps{
+		set:    make(map[string]*template.Template),
+		add:    make(map[string]*template.Template),
+		remove: ops.Remove,
+		rename: ops.Rename,
+	}
+	for key, value := range ops.Set {
+		tmpl, err := template.New(key).Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		compiled.set[key] = tmpl
+	}
+	for key, value := range ops.Add {
+		tmpl, err := template.New(key).Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		compiled.add[key] = tmpl
+	}
+	return compiled, nil
+}
+
+func (ops *compiledHeaderOps) empty() bool {
+	return len(ops.set) == 0 && len(ops.add) == 0 && len(ops.remove) == 0 && len(ops.rename) == 0
+}
+
+func (ops *compiledHeaderOps) apply(header http.Header, vars *headerVars) {
+	for _, key := range ops.remove {
+		header.Del(key)
+	}
+	for from, to := range ops.rename {
+		if values := header.Values(from); len(values) > 0 {
+			header.Del(from)
+			header[http.CanonicalHeaderKey(to)] = values
+		}
+	}
+	for key, tmpl := range ops.set {
+		header.Set(key, render(tmpl, vars))
+	}
+	for key, tmpl := range ops.add {
+		header.Add(key, render(tmpl, vars))
+	}
+}
+
+func render(tmpl *template.Template, vars *headerVars) string {
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+func headersConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &headersOpts{}
+	err := mapstructure.Decode(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	request, err := compileHeaderOps(opts.Request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := compileHeaderOps(opts.Response)
+	if err != nil {
+		return nil, err
+	}
+	return &headers{next, request, response}, nil
+}
+
+type headers struct {
+	next     http.Handler
+	request  *compiledHeaderOps
+	response *compiledHeaderOps
+}
+
+func (mw *headers) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !mw.request.empty() {
+		req = req.WithContext(req.Context())
+		req.Header = req.Header.Clone()
+		mw.request.apply(req.Header, newHeaderVars(req))
+	}
+	if !mw.response.empty() {
+		w = &hookWriter{ResponseWriter: w, hook: func(w http.ResponseWriter, status int) {
+			mw.response.apply(w.Header(), newHeaderVars(req))
+		}}
+	}
+	mw.next.ServeHTTP(w, req)
+}
+
+func init() {
+	registry.Register("headers", headersConstructor)
+}
@@ -0,0 +1,148 @@
+package builtin
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// parseHtpasswd parses user:hash lines. Empty lines and comments are skipped.
+func parseHtpasswd(lines []string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, line := range lines {
+		for _, entry := range strings.Split(line, "\n") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+			parts := strings.SplitN(entry, ":", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("malformed htpasswd entry for '%v'", parts[0])
+			}
+			users[parts[0]] = parts[1]
+		}
+	}
+	return users, nil
+}
+
+// verifyPassword checks a password against a bcrypt, argon2, apr1 or {SHA} hash
+func verifyPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2"):
+		return verifyArgon2(hash, password)
+	case strings.HasPrefix(hash, "$apr1$"):
+		return subtle.ConstantTimeCompare([]byte(apr1(password, hash)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+	return false
+}
+
+// verifyArgon2 checks a PHC formatted argon2i or argon2id hash: $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+func verifyArgon2(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	var actual []byte
+	switch parts[1] {
+	case "argon2id":
+		actual = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	case "argon2i":
+		actual = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
+
+// apr1 computes the Apache MD5 crypt of password with the salt of hash
+func apr1(password, hash string) string {
+	const magic = "$apr1$"
+	salt := strings.TrimPrefix(hash, magic)
+	if i := strings.Index(salt, "$"); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	alt := md5.Sum([]byte(password + salt + password))
+	var ctx bytes.Buffer
+	ctx.WriteString(password + magic + salt)
+	for i := len(pw); i > 0; i -= 16 {
+		n := i
+		if n > 16 {
+			n = 16
+		}
+		ctx.Write(alt[:n])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.WriteByte(0)
+		} else {
+			ctx.WriteByte(pw[0])
+		}
+	}
+	sum := md5.Sum(ctx.Bytes())
+	for i := 0; i < 1000; i++ {
+		var round bytes.Buffer
+		if i&1 == 1 {
+			round.Write(pw)
+		} else {
+			round.Write(sum[:])
+		}
+		if i%3 != 0 {
+			round.WriteString(salt)
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 == 1 {
+			round.Write(sum[:])
+		} else {
+			round.Write(pw)
+		}
+		sum = md5.Sum(round.Bytes())
+	}
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out strings.Builder
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+	return magic + salt + "$" + out.String()
+}
@@ -0,0 +1,170 @@
+package builtin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/kv"
+	"github.com/trusch/eve/middleware/registry"
+	"github.com/trusch/eve/netutil"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// ipFilterOpts are the options of the ipfilter middleware.
+// Denied networks take precedence, if there are allowed networks all others are rejected.
+type ipFilterOpts struct {
+	// Allow and Deny are CIDRs or IP addresses
+	Allow []string
+	Deny  []string
+	// AllowKey and DenyKey are etcd keys holding whitespace or comma separated CIDRs.
+	// Changes are applied immediately and add to the inline lists.
+	AllowKey string
+	DenyKey  string
+	// TrustedProxies is the number of proxies in front of eve which append to X-Forwarded-For.
+	// With 0 (default) the peer address is used, which is the client address with the PROXY protocol.
+	// It is also used for requests with fewer X-Forwarded-For entries, they didn't pass all proxies.
+	TrustedProxies int
+	// Status of rejected requests (default 403)
+	Status int
+}
+
+func ipFilterConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &ipFilterOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if opts.Status == 0 {
+		opts.Status = http.StatusForbidden
+	}
+	if opts.TrustedProxies < 0 {
+		return nil, errors.New("ipfilter: TrustedProxies must not be negative")
+	}
+	allow, err := netutil.ParsePrefixes(opts.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("ipfilter: %v", err)
+	}
+	deny, err := netutil.ParsePrefixes(opts.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("ipfilter: %v", err)
+	}
+	mw := &ipFilter{next: next, opts: opts, allow: newPrefixList(allow), deny: newPrefixList(deny)}
+	if opts.AllowKey == "" && opts.DenyKey == "" {
+		return mw, nil
+	}
+	store, err := kv.Get()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	mw.cancel = cancel
+	for key, list := range map[string]*prefixList{opts.AllowKey: mw.allow, opts.DenyKey: mw.deny} {
+		if key == "" {
+			continue
+		}
+		value, _, err := store.Get(ctx, key)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if err := list.load(value); err != nil {
+			cancel()
+			return nil, fmt.Errorf("ipfilter: %v: %v", key, err)
+		}
+		go func(key string, list *prefixList) {
+			for value := range store.Watch(ctx, key) {
+				// keep the old list if the new one is malformed
+				if err := list.load(value); err != nil {
+					slog.Warn("ignoring malformed ipfilter list", "key", key, "error", err)
+				}
+			}
+		}(key, list)
+	}
+	return mw, nil
+}
+
+type ipFilter struct {
+	next   http.Handler
+	opts   *ipFilterOpts
+	allow  *prefixList
+	deny   *prefixList
+	cancel context.CancelFunc
+}
+
+func (mw *ipFilter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ip := peerIP(req)
+	if mw.opts.TrustedProxies > 0 {
+		ip = forwardedIP(req, mw.opts.TrustedProxies)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || mw.deny.contains(addr) || (!mw.allow.empty() && !mw.allow.contains(addr)) {
+		http.Error(w, http.StatusText(mw.opts.Status), mw.opts.Status)
+		return
+	}
+	requestinfo.FromRequest(req).ClientIP = addr.String()
+	mw.next.ServeHTTP(w, req)
+}
+
+func (mw *ipFilter) Close() error {
+	if mw.cancel != nil {
+		mw.cancel()
+	}
+	return nil
+}
+
+// prefixList is a list of networks, extended by a hot-reloadable list from etcd
+type prefixList struct {
+	inline []netip.Prefix
+	mutex  sync.RWMutex
+	stored []netip.Prefix
+}
+
+func newPrefixList(inline []netip.Prefix) *prefixList {
+	return &prefixList{inline: inline}
+}
+
+func (list *prefixList) load(value string) error {
+	prefixes, err := netutil.ParsePrefixes(strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}))
+	if err != nil {
+		return err
+	}
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.stored = prefixes
+	return nil
+}
+
+func (list *prefixList) empty() bool {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return len(list.inline) == 0 && len(list.stored) == 0
+}
+
+func (list *prefixList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range list.inline {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	for _, prefix := range list.stored {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registry.Register("ipfilter", ipFilterConstructor)
+}
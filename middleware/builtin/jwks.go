@@ -0,0 +1,279 @@
+package builtin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksMinRefresh limits the refreshes triggered by unknown key IDs, and is the retry interval of failed fetches
+const jwksMinRefresh = 10 * time.Second
+
+// jwks caches the keys of a JSON Web Key Set and refreshes them periodically,
+// and when a token references an unknown key ID, i.e. after a key rotation.
+// The set starts empty and is fetched in the background, so an unavailable provider doesn't block eve.
+type jwks struct {
+	url    string
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex     sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt is the start of the last fetch, successful or not
+	attemptedAt time.Time
+	// fetching is the fetch in flight, nil if there is none
+	fetching *jwksFetch
+}
+
+// jwksFetch is a fetch of the key set, shared by all requests waiting for it
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWKS(url string, refresh time.Duration) *jwks {
+	ctx, cancel := context.WithCancel(context.Background())
+	set := &jwks{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+		keys:   make(map[string]crypto.PublicKey),
+	}
+	go set.run(refresh)
+	return set
+}
+
+// run fetches the set right away and then every interval. Until the first fetch succeeded, it is retried sooner.
+func (set *jwks) run(interval time.Duration) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			next := interval
+			if err := set.fetch(set.ctx); err != nil {
+				slog.Warn("failed to refresh JWKS", "url", set.url, "error", err)
+				set.mutex.RLock()
+				fetched := !set.fetchedAt.IsZero()
+				set.mutex.RUnlock()
+				if !fetched && next > jwksMinRefresh {
+					next = jwksMinRefresh
+				}
+			}
+			timer.Reset(next)
+		case <-set.ctx.Done():
+			return
+		}
+	}
+}
+
+// fetch refreshes the set. Concurrent callers share one request, each waits until it is done or its ctx ends.
+func (set *jwks) fetch(ctx context.Context) error {
+	set.mutex.Lock()
+	f := set.fetching
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		set.fetching = f
+		set.attemptedAt = time.Now()
+		go func() {
+			f.err = set.refresh(set.ctx)
+			set.mutex.Lock()
+			set.fetching = nil
+			set.mutex.Unlock()
+			close(f.done)
+		}()
+	}
+	set.mutex.Unlock()
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetchMissing refreshes the set because a key is missing, at most every jwksMinRefresh
+func (set *jwks) fetchMissing(ctx context.Context) error {
+	set.mutex.RLock()
+	recent := time.Since(set.attemptedAt) < jwksMinRefresh && set.fetching == nil
+	set.mutex.RUnlock()
+	if recent {
+		return nil
+	}
+	return set.fetch(ctx)
+}
+
+func (set *jwks) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, set.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := set.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: %v", resp.Status)
+	}
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("skipping malformed JWK", "url", set.url, "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.keys = keys
+	set.fetchedAt = time.Now()
+	return nil
+}
+
+// key returns the key with the given ID. Unknown IDs trigger a refresh.
+func (set *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	set.mutex.RLock()
+	key, ok := set.keys[kid]
+	set.mutex.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if err := set.fetchMissing(ctx); err != nil {
+		return nil, err
+	}
+	set.mutex.RLock()
+	key, ok = set.keys[kid]
+	set.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%v'", kid)
+	}
+	return key, nil
+}
+
+// tokenKeys returns the keys which may have signed token:
+// the one with its key ID or all of them if it has none
+func (set *jwks) tokenKeys(ctx context.Context, token *jwt.Token) ([]crypto.PublicKey, error) {
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, err := set.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		return []crypto.PublicKey{key}, nil
+	}
+	set.mutex.RLock()
+	empty := len(set.keys) == 0
+	set.mutex.RUnlock()
+	if empty {
+		if err := set.fetchMissing(ctx); err != nil {
+			return nil, err
+		}
+	}
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	keys := make([]crypto.PublicKey, 0, len(set.keys))
+	for _, key := range set.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (set *jwks) Close() error {
+	set.cancel()
+	return nil
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%v'", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%v'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%v'", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
@@ -0,0 +1,275 @@
+package builtin
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// jwtOpts are the options of the jwt middleware
+type jwtOpts struct {
+	// Keys are PEM encoded public keys or certificates
+	Keys []string
+	// Secret is the shared secret for HS256, HS384 and HS512
+	Secret string
+	// JWKSURL is fetched for keys, i.e. https://idp.example.com/.well-known/jwks.json
+	JWKSURL string
+	// JWKSRefresh is the refresh interval of the JWKS (default 1h).
+	// Tokens with an unknown key ID trigger an earlier refresh.
+	JWKSRefresh time.Duration
+	// Algorithms are the accepted signing algorithms (default: all asymmetric ones, HS* if Secret is set)
+	Algorithms []string
+	// Issuer must match the iss claim if set
+	Issuer string
+	// Audience must contain the aud claim if set
+	Audience []string
+	// Leeway is the allowed clock skew for exp and nbf
+	Leeway time.Duration
+	// Claims must be present with the given value. Array claims must contain the value.
+	Claims map[string]string
+	// ClaimHeaders maps claims to headers which are passed upstream
+	ClaimHeaders map[string]string
+	// Cookie is read for the token if there is no Authorization header
+	Cookie string
+	// RemoveHeader strips the Authorization header before forwarding
+	RemoveHeader bool
+}
+
+var asymmetricAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+func jwtConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &jwtOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Keys) == 0 && opts.Secret == "" && opts.JWKSURL == "" {
+		return nil, errors.New("jwt: specify Keys, Secret or JWKSURL")
+	}
+	if opts.JWKSRefresh <= 0 {
+		opts.JWKSRefresh = time.Hour
+	}
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = asymmetricAlgorithms
+		if opts.Secret != "" {
+			opts.Algorithms = append([]string{"HS256", "HS384", "HS512"}, opts.Algorithms...)
+		}
+	}
+	mw := &jwtAuth{next: next, opts: opts}
+	for _, data := range opts.Keys {
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: %v", err)
+		}
+		mw.keys = append(mw.keys, key)
+	}
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(opts.Algorithms),
+		jwt.WithLeeway(opts.Leeway),
+		jwt.WithExpirationRequired(),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	mw.parser = jwt.NewParser(parserOpts...)
+	if opts.JWKSURL != "" {
+		mw.jwks = newJWKS(opts.JWKSURL, opts.JWKSRefresh)
+	}
+	return mw, nil
+}
+
+type jwtAuth struct {
+	next   http.Handler
+	opts   *jwtOpts
+	parser *jwt.Parser
+	keys   []crypto.PublicKey
+	jwks   *jwks
+}
+
+// keyFunc returns the keys which may have signed token
+func (mw *jwtAuth) keyFunc(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	if strings.HasPrefix(token.Method.Alg(), "HS") {
+		if mw.opts.Secret == "" {
+			return nil, errors.New("no secret configured")
+		}
+		return []byte(mw.opts.Secret), nil
+	}
+	set := jwt.VerificationKeySet{}
+	for _, key := range mw.keys {
+		set.Keys = append(set.Keys, key)
+	}
+	if mw.jwks != nil {
+		keys, err := mw.jwks.tokenKeys(ctx, token)
+		if err != nil && len(set.Keys) == 0 {
+			return nil, err
+		}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, errors.New("no keys available")
+	}
+	return set, nil
+}
+
+// token returns the raw token of a request
+func (mw *jwtAuth) token(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return auth[7:]
+		}
+		return ""
+	}
+	if mw.opts.Cookie != "" {
+		if cookie, err := req.Cookie(mw.opts.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// authorize checks the audience and the required claims
+func (mw *jwtAuth) authorize(claims jwt.MapClaims) error {
+	if len(mw.opts.Audience) > 0 {
+		aud, err := claims.GetAudience()
+		if err != nil {
+			return err
+		}
+		if !containsAny(aud, mw.opts.Audience) {
+			return errors.New("audience not accepted")
+		}
+	}
+	for name, want := range mw.opts.Claims {
+		if !claimMatches(claims[name], want) {
+			return fmt.Errorf("claim '%v' does not match", name)
+		}
+	}
+	return nil
+}
+
+func (mw *jwtAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	raw := mw.token(req)
+	if raw == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	claims := jwt.MapClaims{}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return mw.keyFunc(req.Context(), token)
+	}
+	if _, err := mw.parser.ParseWithClaims(raw, claims, keyFunc); err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err := mw.authorize(claims); err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	requestinfo.FromRequest(req).Claims = claims
+	if len(mw.opts.ClaimHeaders) > 0 || mw.opts.RemoveHeader {
+		req = req.WithContext(req.Context())
+		req.Header = req.Header.Clone()
+		for name, header := range mw.opts.ClaimHeaders {
+			// never pass client supplied values for mapped headers
+			req.Header.Del(header)
+			if value, ok := claims[name]; ok {
+				req.Header.Set(header, claimString(value))
+			}
+		}
+		if mw.opts.RemoveHeader {
+			req.Header.Del("Authorization")
+		}
+	}
+	mw.next.ServeHTTP(w, req)
+}
+
+func (mw *jwtAuth) Close() error {
+	if mw.jwks != nil {
+		return mw.jwks.Close()
+	}
+	return nil
+}
+
+// parsePublicKey parses a PEM encoded public key or certificate
+func parsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// claimMatches reports whether a claim equals want or, for arrays, contains it
+func claimMatches(claim interface{}, want string) bool {
+	if values, ok := claim.([]interface{}); ok {
+		for _, value := range values {
+			if fmt.Sprint(value) == want {
+				return true
+			}
+		}
+		return false
+	}
+	return claim != nil && fmt.Sprint(claim) == want
+}
+
+// claimString formats a claim for a header, arrays are comma separated
+func claimString(claim interface{}) string {
+	if values, ok := claim.([]interface{}); ok {
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = fmt.Sprint(value)
+		}
+		return strings.Join(parts, ",")
+	}
+	if f, ok := claim.(float64); ok {
+		// avoid exponent notation for numeric claims like exp
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(claim)
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() {
+	registry.Register("jwt", jwtConstructor)
+	registry.RegisterSecrets("jwt", "Secret")
+}
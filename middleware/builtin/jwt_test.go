@@ -0,0 +1,261 @@
+package builtin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// testJWKS serves the public keys of a rotatable set of RSA keys and counts its fetches
+type testJWKS struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches atomic.Int32
+	delay   time.Duration
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	t.Helper()
+	set := &testJWKS{keys: make(map[string]*rsa.PrivateKey)}
+	set.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		set.fetches.Add(1)
+		set.mutex.Lock()
+		delay := set.delay
+		var doc struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range set.keys {
+			doc.Keys = append(doc.Keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		set.mutex.Unlock()
+		time.Sleep(delay)
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(set.Close)
+	return set
+}
+
+// rotate replaces the keys of the set with a new key
+func (set *testJWKS) rotate(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.keys = map[string]*rsa.PrivateKey{kid: key}
+	return key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestJWTAuth(t *testing.T, options map[string]interface{}) (http.Handler, *jwtAuth) {
+	t.Helper()
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		claims := requestinfo.FromRequest(req).Claims
+		io.WriteString(w, claimString(claims["sub"]))
+	})
+	mw, err := jwtConstructor(next, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := mw.(*jwtAuth)
+	t.Cleanup(func() { auth.Close() })
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info := &requestinfo.Info{}
+		mw.ServeHTTP(w, req.WithContext(requestinfo.NewContext(req.Context(), info)))
+	})
+	return handler, auth
+}
+
+func serveToken(handler http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// allowRefresh lets the next unknown key ID trigger a refresh, as if jwksMinRefresh had passed
+func allowRefresh(set *jwks) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.attemptedAt = time.Time{}
+}
+
+// waitFetched waits until the initial fetch of set is done
+func waitFetched(set *jwks) {
+	for {
+		set.mutex.RLock()
+		fetched := !set.fetchedAt.IsZero() && set.fetching == nil
+		set.mutex.RUnlock()
+		if fetched {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJWTValidation(t *testing.T) {
+	keys := newTestJWKS(t)
+	key := keys.rotate(t, "k1")
+	handler, _ := newTestJWTAuth(t, map[string]interface{}{
+		"JWKSURL":  keys.URL,
+		"Issuer":   "https://idp.test",
+		"Audience": []string{"api"},
+		"Claims":   map[string]string{"role": "admin"},
+	})
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":  "alice",
+			"iss":  "https://idp.test",
+			"aud":  "api",
+			"exp":  now.Add(time.Hour).Unix(),
+			"role": []interface{}{"user", "admin"},
+		}
+	}
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{"valid", valid(), http.StatusOK},
+		{"expired", with("exp", now.Add(-time.Hour).Unix()), http.StatusUnauthorized},
+		{"no expiry", with("exp", nil), http.StatusUnauthorized},
+		{"not yet valid", with("nbf", now.Add(time.Hour).Unix()), http.StatusUnauthorized},
+		{"wrong issuer", with("iss", "https://evil.test"), http.StatusUnauthorized},
+		{"wrong audience", with("aud", "other"), http.StatusForbidden},
+		{"missing claim", with("role", nil), http.StatusForbidden},
+		{"wrong claim", with("role", "user"), http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveToken(handler, signToken(t, key, "k1", test.claims))
+			if w.Code != test.status {
+				t.Fatalf("status = %v, want %v", w.Code, test.status)
+			}
+			if test.status == http.StatusOK && w.Body.String() != "alice" {
+				t.Errorf("claims not passed on, got %q", w.Body.String())
+			}
+		})
+	}
+
+	t.Run("foreign key", func(t *testing.T) {
+		foreign, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := serveToken(handler, signToken(t, foreign, "k1", valid())); w.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %v, want 401", w.Code)
+		}
+	})
+}
+
+func TestJWKSRotation(t *testing.T) {
+	keys := newTestJWKS(t)
+	old := keys.rotate(t, "k1")
+	handler, auth := newTestJWTAuth(t, map[string]interface{}{"JWKSURL": keys.URL})
+	claims := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	waitFetched(auth.jwks)
+	if w := serveToken(handler, signToken(t, old, "k1", claims)); w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want 200", w.Code)
+	}
+
+	current := keys.rotate(t, "k2")
+	fetches := keys.fetches.Load()
+	if w := serveToken(handler, signToken(t, current, "k2", claims)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %v, want 401 before jwksMinRefresh passed", w.Code)
+	}
+	if got := keys.fetches.Load(); got != fetches {
+		t.Fatalf("unknown key id fetched the set %v times within jwksMinRefresh", got-fetches)
+	}
+
+	allowRefresh(auth.jwks)
+	if w := serveToken(handler, signToken(t, current, "k2", claims)); w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want 200 after rotation", w.Code)
+	}
+	if w := serveToken(handler, signToken(t, old, "k1", claims)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %v, want 401 for the rotated out key", w.Code)
+	}
+}
+
+func TestJWKSSharedRefresh(t *testing.T) {
+	keys := newTestJWKS(t)
+	keys.rotate(t, "k1")
+	handler, auth := newTestJWTAuth(t, map[string]interface{}{"JWKSURL": keys.URL})
+	claims := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	waitFetched(auth.jwks)
+	current := keys.rotate(t, "k2")
+	keys.mutex.Lock()
+	keys.delay = 100 * time.Millisecond
+	keys.mutex.Unlock()
+	allowRefresh(auth.jwks)
+	fetches := keys.fetches.Load()
+	token := signToken(t, current, "k2", claims)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := serveToken(handler, token); w.Code != http.StatusOK {
+				t.Errorf("status = %v, want 200", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := keys.fetches.Load() - fetches; got != 1 {
+		t.Fatalf("20 concurrent misses fetched the set %v times, want 1", got)
+	}
+}
+
+func TestJWKSUnavailable(t *testing.T) {
+	// the constructor must not block on or fail with the provider
+	handler, _ := newTestJWTAuth(t, map[string]interface{}{"JWKSURL": "http://127.0.0.1:1/jwks.json"})
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signToken(t, key, "k1", jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	if w := serveToken(handler, token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %v, want 401", w.Code)
+	}
+}
@@ -0,0 +1,552 @@
+package builtin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/registry"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// oidcOpts are the options of the oidc middleware
+type oidcOpts struct {
+	// Issuer is the URL of the provider, its configuration is discovered from /.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested from the provider (default openid, profile, email)
+	Scopes []string
+	// RedirectURL is the callback URL registered at the provider.
+	// By default it is built from the request host and CallbackPath.
+	RedirectURL string
+	// CallbackPath receives the authorization code (default /oauth2/callback)
+	CallbackPath string
+	// LogoutPath ends the session (default /oauth2/logout)
+	LogoutPath string
+	// PostLogoutRedirectURL is where users are sent after logging out at the provider
+	PostLogoutRedirectURL string
+	// CookieName is the name of the session cookie (default eve_session)
+	CookieName string
+	// CookieSecret encrypts and authenticates the session cookie
+	CookieSecret string
+	// CookieDomain allows sharing the session with subdomains
+	CookieDomain string
+	// CookieSecure sends the cookies over HTTPS only (default true). Without RedirectURL it also selects
+	// the scheme of the callback, so TLS terminating proxies in front of eve don't matter.
+	// Disable it only for plain HTTP setups.
+	CookieSecure *bool
+	// SessionLifetime limits the session, independent of token refreshes (default 24h)
+	SessionLifetime time.Duration
+	// ClaimHeaders maps claims to headers which are passed upstream
+	// (default sub to X-Forwarded-User, email to X-Forwarded-Email)
+	ClaimHeaders map[string]string
+	// PassAccessToken passes the access token upstream as bearer token
+	PassAccessToken bool
+	// Claims must be present with the given value. Array claims must contain the value.
+	Claims map[string]string
+}
+
+// oidcRefreshReuse is how long the result of a refresh is handed out for the old refresh token.
+// Requests which were sent with the old session cookie get the new session, instead of reusing the token,
+// which providers with refresh token rotation reject.
+const oidcRefreshReuse = time.Minute
+
+// oidcProvider is the discovered provider configuration
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// tokenResponse is the answer of the token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+func oidcConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &oidcOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if opts.Issuer == "" || opts.ClientID == "" {
+		return nil, errors.New("oidc: specify Issuer and ClientID")
+	}
+	if len(opts.CookieSecret) < 16 {
+		return nil, errors.New("oidc: CookieSecret must have at least 16 characters")
+	}
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = []string{"openid", "profile", "email"}
+	}
+	if opts.CallbackPath == "" {
+		opts.CallbackPath = "/oauth2/callback"
+	}
+	if opts.LogoutPath == "" {
+		opts.LogoutPath = "/oauth2/logout"
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "eve_session"
+	}
+	if opts.CookieSecure == nil {
+		secure := true
+		opts.CookieSecure = &secure
+	}
+	if opts.SessionLifetime <= 0 {
+		opts.SessionLifetime = 24 * time.Hour
+	}
+	if opts.ClaimHeaders == nil {
+		opts.ClaimHeaders = map[string]string{
+			"sub":   "X-Forwarded-User",
+			"email": "X-Forwarded-Email",
+		}
+	}
+	codec, err := newCookieCodec(opts.CookieSecret)
+	if err != nil {
+		return nil, err
+	}
+	mw := &oidcAuth{
+		next:      next,
+		opts:      opts,
+		codec:     codec,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		refreshes: make(map[[sha256.Size]byte]*oidcRefresh),
+	}
+	if err := mw.discover(); err != nil {
+		return nil, fmt.Errorf("oidc: %v", err)
+	}
+	mw.jwks = newJWKS(mw.provider.JWKSURI, time.Hour)
+	mw.parser = jwt.NewParser(
+		jwt.WithValidMethods(asymmetricAlgorithms),
+		jwt.WithIssuer(mw.provider.Issuer),
+		jwt.WithAudience(opts.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	return mw, nil
+}
+
+type oidcAuth struct {
+	next     http.Handler
+	opts     *oidcOpts
+	codec    *cookieCodec
+	client   *http.Client
+	provider oidcProvider
+	jwks     *jwks
+	parser   *jwt.Parser
+
+	mutex sync.Mutex
+	// refreshes are the refreshes in flight or done recently, by the hash of the refresh token
+	refreshes map[[sha256.Size]byte]*oidcRefresh
+}
+
+// oidcRefresh is a refresh of a session, shared by all requests carrying the same refresh token
+type oidcRefresh struct {
+	done    chan struct{}
+	session oidcSession
+	err     error
+	expires time.Time
+}
+
+func (mw *oidcAuth) discover() error {
+	resp, err := mw.client.Get(strings.TrimSuffix(mw.opts.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching provider configuration: %v", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mw.provider); err != nil {
+		return err
+	}
+	if mw.provider.AuthorizationEndpoint == "" || mw.provider.TokenEndpoint == "" || mw.provider.JWKSURI == "" {
+		return errors.New("incomplete provider configuration")
+	}
+	return nil
+}
+
+func (mw *oidcAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case mw.opts.CallbackPath:
+		mw.callback(w, req)
+		return
+	case mw.opts.LogoutPath:
+		mw.logout(w, req)
+		return
+	}
+	session := mw.session(req)
+	if session != nil && time.Now().After(session.Expiry) {
+		refreshed, err := mw.sharedRefresh(req.Context(), session)
+		if err != nil {
+			slog.Debug("failed to refresh oidc session", "error", err)
+		} else if err := mw.setCookie(w, mw.opts.CookieName, refreshed, refreshed.Until); err != nil {
+			slog.Warn("failed to store oidc session", "error", err)
+		}
+		session = refreshed
+	}
+	if session == nil {
+		mw.login(w, req)
+		return
+	}
+	for name, want := range mw.opts.Claims {
+		if !claimMatches(session.Claims[name], want) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+	requestinfo.FromRequest(req).Claims = session.Claims
+	req = req.WithContext(req.Context())
+	req.Header = req.Header.Clone()
+	removeCookie(req, mw.opts.CookieName)
+	for name, header := range mw.opts.ClaimHeaders {
+		req.Header.Del(header)
+		if value, ok := session.Claims[name]; ok {
+			req.Header.Set(header, claimString(value))
+		}
+	}
+	if mw.opts.PassAccessToken {
+		req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+	}
+	mw.next.ServeHTTP(w, req)
+}
+
+// session returns the session of the request or nil
+func (mw *oidcAuth) session(req *http.Request) *oidcSession {
+	cookie, err := req.Cookie(mw.opts.CookieName)
+	if err != nil {
+		return nil
+	}
+	session := &oidcSession{}
+	if err := mw.codec.decode(mw.opts.CookieName, cookie.Value, session); err != nil {
+		return nil
+	}
+	if time.Now().After(session.Until) {
+		return nil
+	}
+	return session
+}
+
+// login redirects browsers to the provider, other clients get a 401
+func (mw *oidcAuth) login(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet || !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	login := &oidcLogin{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString() + randomString(),
+		Redirect: localRedirect(req.URL.RequestURI()),
+	}
+	if err := mw.setCookie(w, mw.loginCookie(), login, time.Now().Add(10*time.Minute)); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {mw.opts.ClientID},
+		"redirect_uri":          {mw.redirectURL(req)},
+		"scope":                 {strings.Join(mw.opts.Scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, req, withQuery(mw.provider.AuthorizationEndpoint, params), http.StatusFound)
+}
+
+func (mw *oidcAuth) callback(w http.ResponseWriter, req *http.Request) {
+	cookie, err := req.Cookie(mw.loginCookie())
+	login := &oidcLogin{}
+	if err != nil || mw.codec.decode(mw.loginCookie(), cookie.Value, login) != nil {
+		http.Error(w, "login expired, please retry", http.StatusBadRequest)
+		return
+	}
+	mw.clearCookie(w, mw.loginCookie())
+	query := req.URL.Query()
+	if query.Get("state") != login.State {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "login failed: "+errCode, http.StatusForbidden)
+		return
+	}
+	tokens, err := mw.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {mw.redirectURL(req)},
+		"code_verifier": {login.Verifier},
+	})
+	if err != nil {
+		slog.Warn("oidc code exchange failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	claims, err := mw.verify(req.Context(), tokens.IDToken)
+	if err != nil || claims["nonce"] != login.Nonce {
+		slog.Warn("invalid oidc id token", "error", err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	session := &oidcSession{Until: time.Now().Add(mw.opts.SessionLifetime)}
+	mw.update(session, tokens, claims)
+	if err := mw.setCookie(w, mw.opts.CookieName, session, session.Until); err != nil {
+		slog.Warn("failed to store oidc session", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, req, localRedirect(login.Redirect), http.StatusFound)
+}
+
+// localRedirect returns target if it is a path on this host, otherwise /.
+// Browsers treat //host and /\host as references to other hosts.
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func (mw *oidcAuth) logout(w http.ResponseWriter, req *http.Request) {
+	mw.clearCookie(w, mw.opts.CookieName)
+	target := mw.opts.PostLogoutRedirectURL
+	if mw.provider.EndSessionEndpoint != "" {
+		params := url.Values{"client_id": {mw.opts.ClientID}}
+		if target != "" {
+			params.Set("post_logout_redirect_uri", target)
+		}
+		target = withQuery(mw.provider.EndSessionEndpoint, params)
+	}
+	if target == "" {
+		target = "/"
+	}
+	http.Redirect(w, req, target, http.StatusFound)
+}
+
+// sharedRefresh returns session with renewed tokens. Concurrent and recent refreshes of the same
+// refresh token share one request to the provider, since many providers accept a refresh token only once.
+func (mw *oidcAuth) sharedRefresh(ctx context.Context, session *oidcSession) (*oidcSession, error) {
+	if session.RefreshToken == "" {
+		return nil, errors.New("no refresh token")
+	}
+	key := sha256.Sum256([]byte(session.RefreshToken))
+	now := time.Now()
+	mw.mutex.Lock()
+	r, ok := mw.refreshes[key]
+	if !ok || (!r.expires.IsZero() && now.After(r.expires)) {
+		for k, old := range mw.refreshes {
+			if !old.expires.IsZero() && now.After(old.expires) {
+				delete(mw.refreshes, k)
+			}
+		}
+		r = &oidcRefresh{done: make(chan struct{}), session: *session}
+		mw.refreshes[key] = r
+		go func() {
+			// the refresh outlives the request which started it, others may wait for it
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			r.err = mw.refresh(ctx, &r.session)
+			mw.mutex.Lock()
+			r.expires = time.Now().Add(oidcRefreshReuse)
+			mw.mutex.Unlock()
+			close(r.done)
+		}()
+	}
+	mw.mutex.Unlock()
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	refreshed := r.session
+	return &refreshed, nil
+}
+
+// refresh renews the tokens of session
+func (mw *oidcAuth) refresh(ctx context.Context, session *oidcSession) error {
+	if session.RefreshToken == "" {
+		return errors.New("no refresh token")
+	}
+	tokens, err := mw.token(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {session.RefreshToken},
+	})
+	if err != nil {
+		return err
+	}
+	var claims jwt.MapClaims
+	// providers may omit the id token on refresh, the old claims stay valid then
+	if tokens.IDToken != "" {
+		if claims, err = mw.verify(ctx, tokens.IDToken); err != nil {
+			return err
+		}
+	}
+	mw.update(session, tokens, claims)
+	return nil
+}
+
+// update stores tokens and the claims needed later in session, keeping the cookie small
+func (mw *oidcAuth) update(session *oidcSession, tokens *tokenResponse, claims jwt.MapClaims) {
+	if claims != nil {
+		session.Claims = map[string]interface{}{"sub": claims["sub"]}
+		for name := range mw.opts.ClaimHeaders {
+			if value, ok := claims[name]; ok {
+				session.Claims[name] = value
+			}
+		}
+		for name := range mw.opts.Claims {
+			if value, ok := claims[name]; ok {
+				session.Claims[name] = value
+			}
+		}
+	}
+	if mw.opts.PassAccessToken {
+		session.AccessToken = tokens.AccessToken
+	}
+	if tokens.RefreshToken != "" {
+		session.RefreshToken = tokens.RefreshToken
+	}
+	session.Expiry = time.Now().Add(5 * time.Minute)
+	if tokens.ExpiresIn > 0 {
+		session.Expiry = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	}
+}
+
+// token calls the token endpoint
+func (mw *oidcAuth) token(params url.Values) (*tokenResponse, error) {
+	params.Set("client_id", mw.opts.ClientID)
+	req, err := http.NewRequest(http.MethodPost, mw.provider.TokenEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if mw.opts.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(mw.opts.ClientID), url.QueryEscape(mw.opts.ClientSecret))
+	}
+	resp, err := mw.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tokens := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tokens); err != nil {
+		return nil, fmt.Errorf("token endpoint: %v: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %v: %v %v", resp.Status, tokens.Error, tokens.Description)
+	}
+	return tokens, nil
+}
+
+// verify checks the signature, issuer, audience and expiry of an id token
+func (mw *oidcAuth) verify(ctx context.Context, idToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := mw.parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		keys, err := mw.jwks.tokenKeys(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
+	})
+	return claims, err
+}
+
+func (mw *oidcAuth) redirectURL(req *http.Request) string {
+	if mw.opts.RedirectURL != "" {
+		return mw.opts.RedirectURL
+	}
+	scheme := "http"
+	if *mw.opts.CookieSecure {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + mw.opts.CallbackPath
+}
+
+func (mw *oidcAuth) loginCookie() string {
+	return mw.opts.CookieName + "_login"
+}
+
+func (mw *oidcAuth) setCookie(w http.ResponseWriter, name string, value interface{}, expires time.Time) error {
+	encoded, err := mw.codec.encode(name, value)
+	if err != nil {
+		return err
+	}
+	if len(name)+len(encoded) > maxCookieSize {
+		return fmt.Errorf("cookie %v exceeds %v bytes", name, maxCookieSize)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     "/",
+		Domain:   mw.opts.CookieDomain,
+		Expires:  expires,
+		Secure:   *mw.opts.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (mw *oidcAuth) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		Domain:   mw.opts.CookieDomain,
+		MaxAge:   -1,
+		Secure:   *mw.opts.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (mw *oidcAuth) Close() error {
+	return mw.jwks.Close()
+}
+
+// randomString returns 32 random bytes, url-safe encoded
+func randomString() string {
+	bs := make([]byte, 32)
+	rand.Read(bs)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func withQuery(endpoint string, params url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + params.Encode()
+	}
+	return endpoint + "?" + params.Encode()
+}
+
+func init() {
+	registry.Register("oidc", oidcConstructor)
+	registry.RegisterSecrets("oidc", "ClientSecret", "CookieSecret")
+}
@@ -0,0 +1,329 @@
+package builtin
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// mockProvider is an OpenID Connect provider which issues tokens for one user.
+// Refresh tokens are rotated and accepted only once.
+type mockProvider struct {
+	*httptest.Server
+	t    *testing.T
+	jwks *testJWKS
+	key  *rsa.PrivateKey
+
+	mutex sync.Mutex
+	// challenges are the PKCE challenges and nonces of the issued codes
+	challenges map[string][2]string
+	// refreshTokens are the valid refresh tokens
+	refreshTokens map[string]bool
+	refreshes     int
+	issued        int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	provider := &mockProvider{
+		t:             t,
+		jwks:          newTestJWKS(t),
+		challenges:    make(map[string][2]string),
+		refreshTokens: make(map[string]bool),
+	}
+	provider.key = provider.jwks.rotate(t, "k1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                provider.URL,
+			AuthorizationEndpoint: provider.URL + "/authorize",
+			TokenEndpoint:         provider.URL + "/token",
+			JWKSURI:               provider.jwks.URL,
+			EndSessionEndpoint:    provider.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/token", provider.token)
+	provider.Server = httptest.NewServer(mux)
+	t.Cleanup(provider.Close)
+	return provider
+}
+
+// authorize plays the login at the provider and returns the code for the authorization request
+func (provider *mockProvider) authorize(location string) (code, state string) {
+	provider.t.Helper()
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, provider.URL+"/authorize") {
+		provider.t.Fatalf("unexpected login redirect %q", location)
+	}
+	query := u.Query()
+	// eve may run behind a TLS terminating proxy, so the scheme doesn't come from the request
+	if query.Get("redirect_uri") != "https://app.test/oauth2/callback" {
+		provider.t.Fatalf("unexpected redirect_uri %q", query.Get("redirect_uri"))
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "app" {
+		provider.t.Fatalf("unexpected authorization request %v", query)
+	}
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.issued++
+	code = "code-" + strconv.Itoa(provider.issued)
+	provider.challenges[code] = [2]string{query.Get("code_challenge"), query.Get("nonce")}
+	return code, query.Get("state")
+}
+
+func (provider *mockProvider) token(w http.ResponseWriter, req *http.Request) {
+	if user, password, _ := req.BasicAuth(); user != "app" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_client"})
+		return
+	}
+	req.ParseForm()
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	nonce := ""
+	switch req.Form.Get("grant_type") {
+	case "authorization_code":
+		challenge, ok := provider.challenges[req.Form.Get("code")]
+		delete(provider.challenges, req.Form.Get("code"))
+		verifier := sha256.Sum256([]byte(req.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge[0] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+		nonce = challenge[1]
+	case "refresh_token":
+		if !provider.refreshTokens[req.Form.Get("refresh_token")] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+		delete(provider.refreshTokens, req.Form.Get("refresh_token"))
+		provider.refreshes++
+		// a slow provider, so concurrent requests overlap
+		time.Sleep(50 * time.Millisecond)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	provider.issued++
+	refreshToken := "refresh-" + strconv.Itoa(provider.issued)
+	provider.refreshTokens[refreshToken] = true
+	claims := jwt.MapClaims{
+		"iss":   provider.URL,
+		"aud":   "app",
+		"sub":   "alice",
+		"email": "alice@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  "access-" + strconv.Itoa(provider.issued),
+		IDToken:      signToken(provider.t, provider.key, "k1", claims),
+		RefreshToken: refreshToken,
+		ExpiresIn:    3600,
+	})
+}
+
+// oidcClient is a browser with a cookie jar
+type oidcClient struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (client *oidcClient) get(target string) *httptest.ResponseRecorder {
+	client.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept", "text/html")
+	for _, cookie := range client.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	client.handler.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(client.cookies, cookie.Name)
+		} else {
+			client.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+func newTestOIDC(t *testing.T, provider *mockProvider) (*oidcAuth, *oidcClient) {
+	t.Helper()
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := req.Cookie("eve_session"); err == nil {
+			t.Error("session cookie passed upstream")
+		}
+		w.Header().Set("X-User", req.Header.Get("X-Forwarded-User"))
+		w.Header().Set("X-Token", req.Header.Get("Authorization"))
+		w.Write([]byte("ok"))
+	})
+	mw, err := oidcConstructor(next, map[string]interface{}{
+		"Issuer":          provider.URL,
+		"ClientID":        "app",
+		"ClientSecret":    "secret",
+		"CookieSecret":    "0123456789abcdef",
+		"PassAccessToken": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := mw.(*oidcAuth)
+	t.Cleanup(func() { auth.Close() })
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := requestinfo.NewContext(req.Context(), &requestinfo.Info{})
+		mw.ServeHTTP(w, req.WithContext(ctx))
+	})
+	return auth, &oidcClient{t: t, handler: handler, cookies: make(map[string]*http.Cookie)}
+}
+
+// login runs the authorization code flow and returns the redirect after the callback
+func login(t *testing.T, provider *mockProvider, client *oidcClient, target string) string {
+	t.Helper()
+	w := client.get(target)
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %v, want a redirect to the provider", w.Code)
+	}
+	code, state := provider.authorize(w.Header().Get("Location"))
+	w = client.get("http://app.test/oauth2/callback?" + url.Values{"code": {code}, "state": {state}}.Encode())
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback status = %v: %v", w.Code, w.Body.String())
+	}
+	return w.Header().Get("Location")
+}
+
+// expireSession makes the tokens of the session cookie of client expire
+func expireSession(t *testing.T, auth *oidcAuth, client *oidcClient) {
+	t.Helper()
+	session := &oidcSession{}
+	if err := auth.codec.decode("eve_session", client.cookies["eve_session"].Value, session); err != nil {
+		t.Fatal(err)
+	}
+	session.Expiry = time.Now().Add(-time.Second)
+	encoded, err := auth.codec.encode("eve_session", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.cookies["eve_session"] = &http.Cookie{Name: "eve_session", Value: encoded}
+}
+
+func TestOIDCFlow(t *testing.T) {
+	provider := newMockProvider(t)
+	auth, client := newTestOIDC(t, provider)
+
+	// api clients get no redirect
+	req := httptest.NewRequest(http.MethodGet, "http://app.test/api", nil)
+	w := httptest.NewRecorder()
+	client.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %v, want 401 without session", w.Code)
+	}
+
+	if location := login(t, provider, client, "http://app.test/page?x=1"); location != "/page?x=1" {
+		t.Fatalf("redirect after login = %q, want /page?x=1", location)
+	}
+	if _, ok := client.cookies["eve_session_login"]; ok {
+		t.Error("login cookie not cleared")
+	}
+	if !client.cookies["eve_session"].Secure {
+		t.Error("session cookie sent over plain HTTP")
+	}
+	w = client.get("http://app.test/page")
+	if w.Code != http.StatusOK || w.Header().Get("X-User") != "alice" {
+		t.Fatalf("status = %v, user = %q, want 200 and alice", w.Code, w.Header().Get("X-User"))
+	}
+	firstToken := w.Header().Get("X-Token")
+
+	// concurrent requests with expired tokens share one refresh
+	expireSession(t, auth, client)
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stale := &oidcClient{t: t, handler: client.handler, cookies: map[string]*http.Cookie{"eve_session": client.cookies["eve_session"]}}
+			results[i] = stale.get("http://app.test/page")
+		}(i)
+	}
+	wg.Wait()
+	for _, w := range results {
+		if w.Code != http.StatusOK || w.Header().Get("X-Token") == firstToken {
+			t.Fatalf("status = %v, token = %q, want 200 with a refreshed token", w.Code, w.Header().Get("X-Token"))
+		}
+	}
+	// a request sent with the old cookie shortly after gets the same session
+	if w := client.get("http://app.test/page"); w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want 200 for the old cookie within the reuse window", w.Code)
+	}
+	provider.mutex.Lock()
+	refreshes := provider.refreshes
+	provider.mutex.Unlock()
+	if refreshes != 1 {
+		t.Fatalf("refresh token used %v times, want 1", refreshes)
+	}
+
+	w = client.get("http://app.test/oauth2/logout")
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), provider.URL+"/logout") {
+		t.Fatalf("logout: status = %v, location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if _, ok := client.cookies["eve_session"]; ok {
+		t.Fatal("session cookie not cleared on logout")
+	}
+	if cleared := w.Result().Cookies(); len(cleared) != 1 || !cleared[0].Secure || cleared[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie cleared with other attributes: %v", cleared)
+	}
+	if w := client.get("http://app.test/page"); w.Code != http.StatusFound {
+		t.Fatalf("status = %v, want a login redirect after logout", w.Code)
+	}
+}
+
+func TestOIDCRedirectStaysLocal(t *testing.T) {
+	provider := newMockProvider(t)
+	_, client := newTestOIDC(t, provider)
+	for _, target := range []string{"http://app.test//evil.test/x", "http://app.test/%5Cevil.test"} {
+		client.cookies = make(map[string]*http.Cookie)
+		if location := login(t, provider, client, target); strings.HasPrefix(location, "//") || strings.HasPrefix(location, "/\\") {
+			t.Errorf("%v: redirect after login = %q", target, location)
+		}
+	}
+	for _, target := range []string{"//evil.test", "/\\evil.test", "https://evil.test", "", "evil"} {
+		if got := localRedirect(target); got != "/" {
+			t.Errorf("localRedirect(%q) = %q, want /", target, got)
+		}
+	}
+	if got := localRedirect("/a//b?c=//d"); got != "/a//b?c=//d" {
+		t.Errorf("localRedirect kept %q", got)
+	}
+}
+
+func TestOIDCRejectsWrongState(t *testing.T) {
+	provider := newMockProvider(t)
+	_, client := newTestOIDC(t, provider)
+	w := client.get("http://app.test/page")
+	code, _ := provider.authorize(w.Header().Get("Location"))
+	w = client.get("http://app.test/oauth2/callback?" + url.Values{"code": {code}, "state": {"forged"}}.Encode())
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %v, want 400", w.Code)
+	}
+	if _, ok := client.cookies["eve_session"]; ok {
+		t.Fatal("session created with a forged state")
+	}
+}
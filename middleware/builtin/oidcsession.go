@@ -0,0 +1,101 @@
+package builtin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxCookieSize is the size most browsers accept for a single cookie
+const maxCookieSize = 4096
+
+// oidcSession is stored in the session cookie
+type oidcSession struct {
+	Claims       map[string]interface{} `json:"c"`
+	AccessToken  string                 `json:"a,omitempty"`
+	RefreshToken string                 `json:"r,omitempty"`
+	// Expiry is the expiry of the access token, the session is refreshed afterwards
+	Expiry time.Time `json:"e"`
+	// Until is the end of the session, independent of refreshes
+	Until time.Time `json:"u"`
+}
+
+// oidcLogin is stored in a short-lived cookie while the user logs in at the provider
+type oidcLogin struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	// Redirect is the path the user requested before the login
+	Redirect string `json:"r"`
+}
+
+// cookieCodec encrypts cookie values with AES-GCM, which also authenticates them
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+func newCookieCodec(secret string) (*cookieCodec, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieCodec{aead: aead}, nil
+}
+
+// encode encrypts value. The cookie name is authenticated too, so values can't be swapped between cookies.
+func (codec *cookieCodec) encode(name string, value interface{}) (string, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, codec.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := codec.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (codec *cookieCodec) decode(name, encoded string, value interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < codec.aead.NonceSize() {
+		return errors.New("cookie too short")
+	}
+	nonce, ciphertext := sealed[:codec.aead.NonceSize()], sealed[codec.aead.NonceSize():]
+	plaintext, err := codec.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, value)
+}
+
+// removeCookie removes a cookie from the Cookie header of req, so it isn't passed upstream
+func removeCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	parts := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			parts = append(parts, cookie.String())
+		}
+	}
+	if len(parts) > 0 {
+		req.Header.Set("Cookie", strings.Join(parts, "; "))
+	}
+}
@@ -0,0 +1,17 @@
+package builtin
+
+import (
+	"github.com/mitchellh/mapstructure"
+)
+
+// decodeOptions decodes middleware options into opts. Durations can be given as strings like "1m".
+func decodeOptions(options interface{}, opts interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     opts,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(options)
+}
@@ -0,0 +1,161 @@
+package builtin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trusch/eve/middleware"
+	"github.com/trusch/eve/middleware/kv"
+	"github.com/trusch/eve/middleware/registry"
+	"github.com/trusch/eve/requestinfo"
+)
+
+// rateLimitOpts are the options of the ratelimit middleware.
+// Every key gets a token bucket holding Burst tokens which refills with Limit tokens per Period.
+type rateLimitOpts struct {
+	// Limit is the number of requests per Period
+	Limit int
+	// Period is the period of the limit (default 1s)
+	Period time.Duration
+	// Burst is the size of the bucket (default Limit)
+	Burst int
+	// Key selects what is limited: ip (default), header:<name>, claim:<name> or rule.
+	// Claims must be verified by a jwt or oidc middleware before, requests without them are limited by ip.
+	// Behind proxies ip is the proxy, unless an ipfilter middleware with TrustedProxies runs before.
+	Key string
+	// Store is local (default) or etcd. The etcd store shares the counters approximately between all eve instances.
+	Store string
+	// Name identifies the shared counters in etcd, it is required for the etcd store
+	Name string
+	// SyncInterval is the interval the etcd store exchanges counters in (default 1s)
+	SyncInterval time.Duration
+}
+
+// rateStore hands out tokens
+type rateStore interface {
+	take(key string) rateResult
+	Close() error
+}
+
+type rateResult struct {
+	allowed   bool
+	remaining int
+	// reset is the time until the bucket is full again
+	reset time.Duration
+	// retryAfter is the time until the next token is available
+	retryAfter time.Duration
+}
+
+func rateLimitConstructor(next http.Handler, options interface{}) (middleware.Middleware, error) {
+	opts := &rateLimitOpts{}
+	if err := decodeOptions(options, opts); err != nil {
+		return nil, err
+	}
+	if opts.Limit <= 0 {
+		return nil, errors.New("ratelimit: Limit must be positive")
+	}
+	if opts.Period <= 0 {
+		opts.Period = time.Second
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = opts.Limit
+	}
+	if opts.Key == "" {
+		opts.Key = "ip"
+	}
+	keyFunc, err := rateLimitKey(opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	rate := float64(opts.Limit) / opts.Period.Seconds()
+	var store rateStore
+	switch opts.Store {
+	case "", "local":
+		store = newLocalRateStore(rate, opts.Burst)
+	case "etcd":
+		if opts.Name == "" {
+			return nil, errors.New("ratelimit: the etcd store requires a Name")
+		}
+		kvStore, err := kv.Get()
+		if err != nil {
+			return nil, err
+		}
+		if opts.SyncInterval <= 0 {
+			opts.SyncInterval = time.Second
+		}
+		store = newSharedRateStore(kvStore, opts.Name, opts.SyncInterval, newLocalRateStore(rate, opts.Burst))
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown store '%v'", opts.Store)
+	}
+	return &rateLimit{next: next, opts: opts, key: keyFunc, store: store}, nil
+}
+
+type rateLimit struct {
+	next  http.Handler
+	opts  *rateLimitOpts
+	key   func(req *http.Request) string
+	store rateStore
+}
+
+func (mw *rateLimit) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	result := mw.store.take(mw.key(req))
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(mw.opts.Burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+	if !result.allowed {
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	mw.next.ServeHTTP(w, req)
+}
+
+func (mw *rateLimit) Close() error {
+	return mw.store.Close()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitKey returns the function which extracts the limited key of a request.
+// Requests without the header or claim are limited by their client IP. The prefixes keep
+// clients from choosing a value which collides with the bucket of another client's IP.
+func rateLimitKey(key string) (func(req *http.Request) string, error) {
+	switch {
+	case key == "ip":
+		return clientIP, nil
+	case key == "rule":
+		return func(req *http.Request) string {
+			return requestinfo.FromRequest(req).RuleID
+		}, nil
+	case strings.HasPrefix(key, "header:"):
+		name := strings.TrimPrefix(key, "header:")
+		return func(req *http.Request) string {
+			if value := req.Header.Get(name); value != "" {
+				return "value:" + value
+			}
+			return "ip:" + clientIP(req)
+		}, nil
+	case strings.HasPrefix(key, "claim:"):
+		name := strings.TrimPrefix(key, "claim:")
+		// only claims verified by a jwt or oidc middleware count, a client could put anything into an unverified token
+		return func(req *http.Request) string {
+			if value, ok := requestinfo.FromRequest(req).Claims[name]; ok {
+				return "value:" + fmt.Sprint(value)
+			}
+			return "ip:" + clientIP(req)
+		}, nil
+	}
+	return nil, fmt.Errorf("ratelimit: unknown key '%v'", key)
+}
+
+func init() {
+	registry.Register("ratelimit", rateLimitConstructor)
+}
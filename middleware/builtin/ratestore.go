@@ -0,0 +1,238 @@
+package builtin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trusch/eve/middleware/kv"
+)
+
+// tokenBucket is the state of one rate limited key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// used counts all tokens taken by this instance, it is shared by the etcd store
+	used uint64
+}
+
+// localRateStore keeps token buckets in memory
+type localRateStore struct {
+	mutex    sync.Mutex
+	rate     float64
+	burst    float64
+	buckets  map[string]*tokenBucket
+	dirty    map[string]bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newLocalRateStore(rate float64, burst int) *localRateStore {
+	store := &localRateStore{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		dirty:   make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	go store.cleanup()
+	return store
+}
+
+// bucket returns the refilled bucket of key. It must be called with the mutex held.
+func (store *localRateStore) bucket(key string, now time.Time) *tokenBucket {
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: store.burst, last: now}
+		store.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * store.rate
+	if b.tokens > store.burst {
+		b.tokens = store.burst
+	}
+	b.last = now
+	return b
+}
+
+func (store *localRateStore) take(key string) rateResult {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	b := store.bucket(key, time.Now())
+	result := rateResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		b.used++
+		store.dirty[key] = true
+		result.allowed = true
+	} else {
+		result.retryAfter = store.duration(1 - b.tokens)
+	}
+	if b.tokens > 0 {
+		result.remaining = int(b.tokens)
+	}
+	result.reset = store.duration(store.burst - b.tokens)
+	return result
+}
+
+// consume takes tokens used by other instances
+func (store *localRateStore) consume(key string, n uint64) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	b := store.bucket(key, time.Now())
+	b.tokens -= float64(n)
+	if b.tokens < -store.burst {
+		b.tokens = -store.burst
+	}
+}
+
+// usage returns the usage counters of the keys which changed since the last call
+func (store *localRateStore) usage() map[string]uint64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	result := make(map[string]uint64, len(store.dirty))
+	for key := range store.dirty {
+		result[key] = store.buckets[key].used
+	}
+	store.dirty = make(map[string]bool)
+	return result
+}
+
+// has reports whether key has a bucket, buckets are forgotten by cleanup
+func (store *localRateStore) has(key string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	_, ok := store.buckets[key]
+	return ok
+}
+
+func (store *localRateStore) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / store.rate * float64(time.Second))
+}
+
+// cleanup forgets full buckets, they are equal to new ones
+func (store *localRateStore) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			store.mutex.Lock()
+			now := time.Now()
+			for key := range store.buckets {
+				if b := store.bucket(key, now); b.tokens >= store.burst && !store.dirty[key] {
+					delete(store.buckets, key)
+				}
+			}
+			store.mutex.Unlock()
+		case <-store.done:
+			return
+		}
+	}
+}
+
+func (store *localRateStore) Close() error {
+	store.stopOnce.Do(func() { close(store.done) })
+	return nil
+}
+
+// sharedRateStore shares the usage of the local buckets via a kv.Store.
+// Every instance publishes how many tokens it took per key, and takes the tokens
+// the other instances took from its own buckets. So the limit holds approximately cluster-wide.
+// Counters of forgotten buckets are deleted, so the store doesn't grow with every key ever seen.
+type sharedRateStore struct {
+	*localRateStore
+	kv        kv.Store
+	prefix    string
+	instance  string
+	seen      map[string]uint64
+	published map[string]bool
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newSharedRateStore(store kv.Store, name string, interval time.Duration, local *localRateStore) *sharedRateStore {
+	id := make([]byte, 8)
+	rand.Read(id)
+	shared := &sharedRateStore{
+		localRateStore: local,
+		kv:             store,
+		prefix:         "/eve/ratelimit/" + url.PathEscape(name) + "/",
+		instance:       hex.EncodeToString(id),
+		seen:           make(map[string]uint64),
+		published:      make(map[string]bool),
+		done:           make(chan struct{}),
+	}
+	go shared.run(interval)
+	return shared
+}
+
+func (shared *sharedRateStore) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			shared.sync(interval)
+		case <-shared.done:
+			return
+		}
+	}
+}
+
+func (shared *sharedRateStore) sync(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for key, used := range shared.usage() {
+		// counters vanish with the lease of this instance
+		if shared.kv.Put(ctx, shared.counterPath(key), strconv.FormatUint(used, 10), false) == nil {
+			shared.published[key] = true
+		}
+	}
+	for key := range shared.published {
+		if !shared.has(key) && shared.kv.Delete(ctx, shared.counterPath(key)) == nil {
+			delete(shared.published, key)
+		}
+	}
+	counters, err := shared.kv.List(ctx, shared.prefix)
+	if err != nil {
+		return
+	}
+	for path, value := range counters {
+		parts := strings.Split(strings.TrimPrefix(path, shared.prefix), "/")
+		if len(parts) != 2 || parts[1] == shared.instance {
+			continue
+		}
+		key, err := url.PathUnescape(parts[0])
+		if err != nil {
+			continue
+		}
+		used, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if seen, ok := shared.seen[path]; ok && used > seen {
+			shared.consume(key, used-seen)
+		}
+		shared.seen[path] = used
+	}
+	for path := range shared.seen {
+		if _, ok := counters[path]; !ok {
+			delete(shared.seen, path)
+		}
+	}
+}
+
+// counterPath is the path of the counter of key of this instance
+func (shared *sharedRateStore) counterPath(key string) string {
+	return shared.prefix + url.PathEscape(key) + "/" + shared.instance
+}
+
+func (shared *sharedRateStore) Close() error {
+	shared.stopOnce.Do(func() { close(shared.done) })
+	return shared.localRateStore.Close()
+}
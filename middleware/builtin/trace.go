@@ -23,13 +23,27 @@ func traceConstructor(next http.Handler, options interface{}) (middleware.Middle
 	if err != nil {
 		return nil, err
 	}
-	return trace.New(next, w)
+	t, err := trace.New(next, w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &tracer{t, w}, nil
 }
 
 type traceOpts struct {
 	Output string
 }
 
+type tracer struct {
+	*trace.Tracer
+	output *os.File
+}
+
+func (t *tracer) Close() error {
+	return t.output.Close()
+}
+
 func init() {
 	registry.Register("trace", traceConstructor)
 }
@@ -0,0 +1,53 @@
+package builtin
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// hookWriter calls a hook right before the response header is written,
+// so middlewares can change the headers of the upstream response.
+type hookWriter struct {
+	http.ResponseWriter
+	hook        func(w http.ResponseWriter, status int)
+	wroteHeader bool
+}
+
+func (w *hookWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.hook(w.ResponseWriter, status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hookWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hookWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *hookWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.hook(w.ResponseWriter, http.StatusSwitchingProtocols)
+	}
+	return hijacker.Hijack()
+}
+
+func (w *hookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Store is a key value store middlewares can use to read settings and to share state between eve instances
+type Store interface {
+	// Get returns the value of a key and whether it exists
+	Get(ctx context.Context, key string) (string, bool, error)
+	// List returns all keys with the given prefix and their values
+	List(ctx context.Context, prefix string) (map[string]string, error)
+	// Put sets a key. Values which are not persistent vanish when the instance is gone.
+	Put(ctx context.Context, key, value string, persistent bool) error
+	// Delete removes a key
+	Delete(ctx context.Context, key string) error
+	// Watch sends the new value of a key on every change, "" if it is deleted.
+	// The channel is closed when ctx is done.
+	Watch(ctx context.Context, key string) <-chan string
+}
+
+// ErrNoStore is returned if a middleware needs a store but eve runs without one
+var ErrNoStore = errors.New("no key value store available, start eve with --etcd")
+
+var (
+	mutex   sync.RWMutex
+	current Store
+)
+
+// Set sets the store of this eve instance
+func Set(store Store) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	current = store
+}
+
+// Get returns the store of this eve instance
+func Get() (Store, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if current == nil {
+		return nil, ErrNoStore
+	}
+	return current, nil
+}
@@ -1,45 +1,237 @@
 package manager
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/trusch/eve/middleware"
 	"github.com/trusch/eve/middleware/registry"
 	"github.com/trusch/eve/middleware/rule"
+	"github.com/trusch/eve/tracing"
 )
 
 // Manager manages middlewares
 type Manager struct {
-	ruleset *rule.Set
+	ruleset  *rule.Set
+	mutex    sync.RWMutex
+	chains   map[string]*chain
+	building map[string]*build
+	failures map[string]*failure
+	logger   *slog.Logger
 }
 
+// chain is a built middleware chain of a rule.
+// Chains are cached, so middlewares can keep state between requests.
+type chain struct {
+	rule        *rule.Rule
+	handler     http.Handler
+	middlewares []middleware.Middleware
+	// inFlight counts the requests using the chain, it is closed after the last one finished
+	inFlight sync.WaitGroup
+}
+
+// build is a running build of a chain. Concurrent requests for the same rule wait for it.
+type build struct {
+	rule *rule.Rule
+	done chan struct{}
+	// stale is set if the rule changed during the build
+	stale bool
+}
+
+// failure is a failed build. It is retried after a backoff, not on every request.
+type failure struct {
+	rule    *rule.Rule
+	err     error
+	until   time.Time
+	backoff time.Duration
+}
+
+const (
+	minBuildBackoff = time.Second
+	maxBuildBackoff = time.Minute
+)
+
 // New returns a new Manager
-func New() *Manager {
-	return &Manager{rule.NewSet()}
+func New(logger *slog.Logger) *Manager {
+	return &Manager{
+		ruleset:  rule.NewSet(),
+		chains:   make(map[string]*chain),
+		building: make(map[string]*build),
+		failures: make(map[string]*failure),
+		logger:   logger,
+	}
 }
 
 // UpsertRule upserts a loadbalancer rule
 func (mgr *Manager) UpsertRule(rule *rule.Rule) error {
+	mgr.dropChain(rule.ID)
 	return mgr.ruleset.UpsertRule(rule)
 }
 
 // RemoveRule removes a rule from the current rule-set
 func (mgr *Manager) RemoveRule(id string) error {
+	mgr.dropChain(id)
 	return mgr.ruleset.RemoveRule(id)
 }
 
-// BuildChain returns a middleware chain which is finalized by the given next handler
-func (mgr *Manager) BuildChain(req *http.Request, next http.Handler) (http.Handler, error) {
-	cfgs, err := mgr.ruleset.GetMiddlewares(req)
+// Rules returns copies of all active middleware rules, with secret options redacted
+func (mgr *Manager) Rules() []*rule.Rule {
+	rules := mgr.ruleset.Rules()
+	redacted := make([]*rule.Rule, 0, len(rules))
+	for _, r := range rules {
+		copied := *r
+		copied.Middlewares = make([]*rule.Config, 0, len(r.Middlewares))
+		for _, cfg := range r.Middlewares {
+			copied.Middlewares = append(copied.Middlewares, &rule.Config{ID: cfg.ID, Opts: registry.Redact(cfg.ID, cfg.Opts)})
+		}
+		redacted = append(redacted, &copied)
+	}
+	return redacted
+}
+
+// BuildChain returns a middleware chain which is finalized by the given next handler.
+// Chains are cached per rule, so next must be the same handler on every call.
+// The returned release function must be called once the request is done with the chain.
+func (mgr *Manager) BuildChain(req *http.Request, next http.Handler) (http.Handler, func(), error) {
+	r, err := mgr.ruleset.GetRule(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if r == nil {
+		return next, func() {}, nil
+	}
+	for {
+		mgr.mutex.RLock()
+		c, err := mgr.cached(r)
+		mgr.mutex.RUnlock()
+		if c != nil || err != nil {
+			return mgr.acquired(c, err)
+		}
+
+		mgr.mutex.Lock()
+		if c, err := mgr.cached(r); c != nil || err != nil {
+			mgr.mutex.Unlock()
+			return mgr.acquired(c, err)
+		}
+		if b, ok := mgr.building[r.ID]; ok && b.rule == r {
+			mgr.mutex.Unlock()
+			<-b.done
+			continue
+		}
+		b := &build{rule: r, done: make(chan struct{})}
+		mgr.building[r.ID] = b
+		mgr.mutex.Unlock()
+
+		// constructors may do I/O, i.e. fetch keys, so they run without holding the lock
+		c, err = mgr.build(r, next)
+		return mgr.acquired(mgr.finish(b, c, err))
+	}
+}
+
+// cached returns the chain of r or the error of its last build, if it is still backing off.
+// The chain is acquired for a request. The caller holds the lock.
+func (mgr *Manager) cached(r *rule.Rule) (*chain, error) {
+	if c, ok := mgr.chains[r.ID]; ok && c.rule == r {
+		c.inFlight.Add(1)
+		return c, nil
+	}
+	if f, ok := mgr.failures[r.ID]; ok && f.rule == r && time.Now().Before(f.until) {
+		return nil, f.err
 	}
-	for i := len(cfgs) - 1; i >= 0; i-- {
-		cfg := cfgs[i]
+	return nil, nil
+}
+
+func (mgr *Manager) acquired(c *chain, err error) (http.Handler, func(), error) {
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.handler, c.inFlight.Done, nil
+}
+
+func (mgr *Manager) build(r *rule.Rule, next http.Handler) (*chain, error) {
+	mgr.logger.Debug("building middleware chain", "rule", r.ID)
+	c := &chain{rule: r}
+	for i := len(r.Middlewares) - 1; i >= 0; i-- {
+		cfg := r.Middlewares[i]
 		h, err := registry.Create(cfg.ID, next, cfg.Opts)
 		if err != nil {
+			mgr.closeMiddlewares(c.middlewares)
 			return nil, err
 		}
-		next = h
+		c.middlewares = append(c.middlewares, h)
+		next = tracing.Middleware(cfg.ID, h)
+	}
+	c.handler = next
+	return c, nil
+}
+
+// finish stores the result of a build and wakes up the requests waiting for it.
+// A built chain is returned acquired for the request which built it.
+func (mgr *Manager) finish(b *build, c *chain, err error) (*chain, error) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	defer close(b.done)
+	if mgr.building[b.rule.ID] == b {
+		delete(mgr.building, b.rule.ID)
+	}
+	if err != nil {
+		if !b.stale {
+			backoff := minBuildBackoff
+			if f, ok := mgr.failures[b.rule.ID]; ok && f.rule == b.rule {
+				backoff = min(2*f.backoff, maxBuildBackoff)
+			}
+			mgr.logger.Warn("failed to build middleware chain", "rule", b.rule.ID, "retry", backoff, "error", err)
+			mgr.failures[b.rule.ID] = &failure{rule: b.rule, err: err, until: time.Now().Add(backoff), backoff: backoff}
+		}
+		return nil, err
+	}
+	delete(mgr.failures, b.rule.ID)
+	c.inFlight.Add(1)
+	if b.stale {
+		// the rule changed meanwhile, the chain only serves the request which built it
+		mgr.retire(c)
+		return c, nil
+	}
+	if old, ok := mgr.chains[b.rule.ID]; ok {
+		mgr.retire(old)
+	}
+	mgr.chains[b.rule.ID] = c
+	return c, nil
+}
+
+func (mgr *Manager) dropChain(id string) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if c, ok := mgr.chains[id]; ok {
+		mgr.retire(c)
+		delete(mgr.chains, id)
+	}
+	if b, ok := mgr.building[id]; ok {
+		b.stale = true
+		delete(mgr.building, id)
+	}
+	delete(mgr.failures, id)
+}
+
+// retire closes the middlewares of a chain which is no longer cached, once its in-flight requests finished.
+// The caller holds the lock, so no new requests can acquire the chain.
+func (mgr *Manager) retire(c *chain) {
+	go func() {
+		c.inFlight.Wait()
+		mgr.closeMiddlewares(c.middlewares)
+	}()
+}
+
+// closeMiddlewares releases the resources of middlewares which hold some (files, connections, ...)
+func (mgr *Manager) closeMiddlewares(middlewares []middleware.Middleware) {
+	for _, mw := range middlewares {
+		if closer, ok := mw.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				mgr.logger.Warn("failed to close middleware", "error", err)
+			}
+		}
 	}
-	return next, nil
 }
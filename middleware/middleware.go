@@ -3,6 +3,8 @@ package middleware
 import "net/http"
 
 // Middleware is the middleware type (simple http.Handler)
+// Middlewares are kept until their rule changes. If they hold resources,
+// they should implement io.Closer to release them.
 type Middleware http.Handler
 
 // Constructor is the signature of a middleware constructor
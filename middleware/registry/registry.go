@@ -3,12 +3,14 @@ package registry
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/trusch/eve/middleware"
 )
 
 var (
 	constructors = make(map[string]middleware.Constructor)
+	secrets      = make(map[string][]string)
 )
 
 // Register registers a new middleware type
@@ -16,6 +18,11 @@ func Register(id string, constructor middleware.Constructor) {
 	constructors[id] = constructor
 }
 
+// RegisterSecrets marks options of a middleware type as secret, they are never shown by the admin API
+func RegisterSecrets(id string, options ...string) {
+	secrets[id] = append(secrets[id], options...)
+}
+
 // Create constructs a new middleware instance
 func Create(id string, next http.Handler, options interface{}) (middleware.Middleware, error) {
 	constructor, ok := constructors[id]
@@ -24,3 +31,27 @@ func Create(id string, next http.Handler, options interface{}) (middleware.Middl
 	}
 	return constructor(next, options)
 }
+
+// Redact returns a copy of the options with the secret options of the middleware type replaced by <redacted>.
+// Options which aren't an object are redacted entirely if the type has secrets.
+func Redact(id string, options interface{}) interface{} {
+	names := secrets[id]
+	if len(names) == 0 || options == nil {
+		return options
+	}
+	opts, ok := options.(map[string]interface{})
+	if !ok {
+		return "<redacted>"
+	}
+	redacted := make(map[string]interface{}, len(opts))
+	for key, value := range opts {
+		redacted[key] = value
+		for _, name := range names {
+			// option names are matched case insensitive when they are decoded
+			if strings.EqualFold(key, name) {
+				redacted[key] = "<redacted>"
+			}
+		}
+	}
+	return redacted
+}
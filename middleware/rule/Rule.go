@@ -3,6 +3,8 @@ package rule
 import (
 	"errors"
 	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/vulcand/route"
 )
@@ -22,18 +24,26 @@ type Config struct {
 
 // A Set is a set of rules which match requests to middleware configs
 type Set struct {
+	mutex  sync.RWMutex
 	rules  map[string]*Rule
 	router route.Router
 }
 
 // UpsertRule upserts a rule
 func (rs *Set) UpsertRule(rule *Rule) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if old, ok := rs.rules[rule.ID]; ok && old.Route != rule.Route {
+		rs.router.RemoveRoute(old.Route)
+	}
 	rs.rules[rule.ID] = rule
-	return rs.router.UpsertRoute(rule.Route, rule.Middlewares)
+	return rs.router.UpsertRoute(rule.Route, rule)
 }
 
 // RemoveRule removes a rule
 func (rs *Set) RemoveRule(id string) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
 	rule, ok := rs.rules[id]
 	if !ok {
 		return errors.New("rule not found")
@@ -42,8 +52,10 @@ func (rs *Set) RemoveRule(id string) error {
 	return rs.router.RemoveRoute(rule.Route)
 }
 
-// GetMiddlewares returns the target middleware config array for a request
-func (rs *Set) GetMiddlewares(req *http.Request) ([]*Config, error) {
+// GetRule returns the rule matching a request, nil if there is none
+func (rs *Set) GetRule(req *http.Request) (*Rule, error) {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
 	target, err := rs.router.Route(req)
 	if err != nil {
 		return nil, err
@@ -51,7 +63,19 @@ func (rs *Set) GetMiddlewares(req *http.Request) ([]*Config, error) {
 	if target == nil {
 		return nil, nil
 	}
-	return target.([]*Config), nil
+	return target.(*Rule), nil
+}
+
+// Rules returns all rules of the set sorted by ID
+func (rs *Set) Rules() []*Rule {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	rules := make([]*Rule, 0, len(rs.rules))
+	for _, rule := range rs.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
 }
 
 // New returns a new rule object
@@ -0,0 +1,68 @@
+package requestinfo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	lbRule "github.com/trusch/eve/loadbalancer/rule"
+)
+
+// Info holds information which is collected while a request passes through eve
+type Info struct {
+	Start            time.Time
+	Rule             *lbRule.Rule
+	RuleID           string
+	Loadbalancer     string
+	Upstream         string
+	UpstreamDuration time.Duration
+	Status           int
+	Bytes            int64
+	RequestID        string
+	// Claims are the verified token claims, set by the jwt middleware
+	Claims map[string]interface{}
+	// ClientIP is the client behind trusted proxies, set by the ipfilter middleware
+	ClientIP string
+	// SplitChosen is set if the weighted split of the rule chose Loadbalancer
+	SplitChosen bool
+}
+
+type contextKey int
+
+const infoKey contextKey = 0
+
+// NewContext returns a new context carrying info
+func NewContext(ctx context.Context, info *Info) context.Context {
+	return context.WithValue(ctx, infoKey, info)
+}
+
+// FromContext returns the info stored in ctx.
+// If there is none, a detached Info is returned so callers never have to check for nil.
+func FromContext(ctx context.Context) *Info {
+	if info, ok := ctx.Value(infoKey).(*Info); ok {
+		return info
+	}
+	return &Info{}
+}
+
+// FromRequest returns the info attached to the request
+func FromRequest(req *http.Request) *Info {
+	return FromContext(req.Context())
+}
+
+// RequestID returns the ID of the request. It is taken from the X-Request-Id header
+// or generated on first use, and stays the same for the whole request.
+func RequestID(req *http.Request) string {
+	info := FromRequest(req)
+	if info.RequestID == "" {
+		info.RequestID = req.Header.Get("X-Request-Id")
+	}
+	if info.RequestID == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		info.RequestID = hex.EncodeToString(id)
+	}
+	return info.RequestID
+}
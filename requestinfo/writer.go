@@ -0,0 +1,69 @@
+package requestinfo
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter records the status code and the number of bytes written
+type ResponseWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+// NewResponseWriter wraps w
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// WriteHeader records the status code and passes it on
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.Status == 0 {
+		w.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the written bytes
+func (w *ResponseWriter) Write(data []byte) (int, error) {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.Bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *ResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.Status == 0 {
+			w.Status = http.StatusSwitchingProtocols
+		}
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// StatusCode returns the recorded status code, 200 if nothing was written yet
+func (w *ResponseWriter) StatusCode() int {
+	if w.Status == 0 {
+		return http.StatusOK
+	}
+	return w.Status
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout limits the time a client has to send the PROXY protocol header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts PROXY protocol v2 headers
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts PROXY protocol (v1 and v2) headers from trusted peers
+// and reports the client address they carry as RemoteAddr
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+func (ln *proxyListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !ln.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (ln *proxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	for _, prefix := range ln.trusted {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the PROXY protocol header on first use,
+// so slow peers don't block the accept loop
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (conn *proxyConn) init() {
+	conn.once.Do(func() {
+		conn.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		conn.remote, conn.err = readProxyHeader(conn.reader)
+		conn.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	conn.init()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(b)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	conn.init()
+	if conn.remote != nil {
+		return conn.remote
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a v1 or v2 header. The returned address is nil for LOCAL and UNKNOWN connections.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	start, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %v", err)
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyV2(reader)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyV1(reader)
+	}
+	return nil, errors.New("missing PROXY header")
+}
+
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	// the header has at most 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY protocol version")
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	// LOCAL connections, i.e. health checks of the proxy, keep their address
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] >> 4 {
+	case 1: // IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("malformed PROXY v2 header")
+		}
+		ip, _ := netip.AddrFromSlice(payload[0:4])
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	case 2: // IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("malformed PROXY v2 header")
+		}
+		ip, _ := netip.AddrFromSlice(payload[0:16])
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	}
+	return nil, nil
+}
@@ -3,11 +3,18 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/trusch/eve/metrics"
+	"github.com/trusch/eve/netutil"
 )
 
 // Server holds two servers: HTTP and HTTPS
@@ -18,28 +25,69 @@ type Server struct {
 	httpServer    *http.Server
 	httpsServer   *http.Server
 	httpsListener net.Listener
+	certMutex     sync.RWMutex
 	certMap       map[string]tls.Certificate
 	certs         []tls.Certificate
+	logger        *slog.Logger
+	proxyTrusted  []netip.Prefix
+}
+
+// CertificateInfo describes a loaded certificate
+type CertificateInfo struct {
+	ID        string
+	Subject   string
+	DNSNames  []string
+	NotBefore time.Time
+	NotAfter  time.Time
 }
 
 // New returns a new server
-func New(handler http.Handler, httpAddr, httpsAddr string) (*Server, error) {
+func New(handler http.Handler, httpAddr, httpsAddr string, logger *slog.Logger) (*Server, error) {
 	srv := &Server{
 		httpAddr:  httpAddr,
 		httpsAddr: httpsAddr,
 		handler:   handler,
 		certMap:   make(map[string]tls.Certificate),
+		logger:    logger,
 	}
 
 	return srv, nil
 }
 
+// SetProxyProtocol enables the PROXY protocol for connections from the given networks.
+// It applies to listeners started afterwards.
+func (srv *Server) SetProxyProtocol(trusted []string) error {
+	prefixes, err := netutil.ParsePrefixes(trusted)
+	if err != nil {
+		return err
+	}
+	srv.proxyTrusted = prefixes
+	return nil
+}
+
+// listen creates a TCP listener, which accepts PROXY protocol headers if enabled
+func (srv *Server) listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(srv.proxyTrusted) > 0 {
+		ln = &proxyListener{Listener: ln, trusted: srv.proxyTrusted}
+	}
+	return ln, nil
+}
+
 // AddCertificate adds a certificate
 func (srv *Server) AddCertificate(id, cert, key string) error {
 	crt, err := tls.X509KeyPair([]byte(cert), []byte(key))
 	if err != nil {
 		return err
 	}
+	if leaf, err := x509.ParseCertificate(crt.Certificate[0]); err == nil {
+		metrics.CertificateExpiry.WithLabelValues(id).Set(float64(leaf.NotAfter.Unix()))
+	}
+	srv.certMutex.Lock()
+	defer srv.certMutex.Unlock()
 	srv.certMap[id] = crt
 	srv.certMapToSlice()
 	return nil
@@ -47,14 +95,38 @@ func (srv *Server) AddCertificate(id, cert, key string) error {
 
 // RemoveCertificate removes a certificate
 func (srv *Server) RemoveCertificate(id string) error {
+	srv.certMutex.Lock()
+	defer srv.certMutex.Unlock()
 	if _, ok := srv.certMap[id]; !ok {
 		return errors.New("certificate doesn't exist")
 	}
 	delete(srv.certMap, id)
+	metrics.CertificateExpiry.DeleteLabelValues(id)
 	srv.certMapToSlice()
 	return nil
 }
 
+// Certificates returns information about all loaded certificates sorted by ID
+func (srv *Server) Certificates() []*CertificateInfo {
+	srv.certMutex.RLock()
+	defer srv.certMutex.RUnlock()
+	infos := make([]*CertificateInfo, 0, len(srv.certMap))
+	for id, crt := range srv.certMap {
+		info := &CertificateInfo{ID: id}
+		if len(crt.Certificate) > 0 {
+			if leaf, err := x509.ParseCertificate(crt.Certificate[0]); err == nil {
+				info.Subject = leaf.Subject.CommonName
+				info.DNSNames = leaf.DNSNames
+				info.NotBefore = leaf.NotBefore
+				info.NotAfter = leaf.NotAfter
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
 func (srv *Server) certMapToSlice() {
 	slice := make([]tls.Certificate, len(srv.certMap))
 	i := 0
@@ -69,28 +141,31 @@ func (srv *Server) certMapToSlice() {
 func (srv *Server) ListenAndServeHTTP() error {
 	if srv.httpServer == nil {
 		srv.httpServer = &http.Server{
-			Addr:    srv.httpAddr,
-			Handler: srv.handler,
+			Addr:     srv.httpAddr,
+			Handler:  srv.handler,
+			ErrorLog: slog.NewLogLogger(srv.logger.Handler(), slog.LevelWarn),
 		}
 	} else {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		srv.httpServer.Shutdown(ctx)
 		cancel()
 	}
-	ln, err := net.Listen("tcp", srv.httpAddr)
+	ln, err := srv.listen(srv.httpAddr)
 	if err != nil {
 		return err
 	}
-	log.Print("created HTTP listener")
+	srv.logger.Debug("created HTTP listener", "addr", srv.httpAddr)
 	go srv.httpServer.Serve(ln)
-	log.Print("started HTTP server")
+	srv.logger.Info("started HTTP server", "addr", srv.httpAddr)
 	return nil
 }
 
 // ListenAndServeHTTPS (re)starts the HTTPS server
 func (srv *Server) ListenAndServeHTTPS() error {
 	config := &tls.Config{}
+	srv.certMutex.RLock()
 	config.Certificates = srv.certs
+	srv.certMutex.RUnlock()
 	config.BuildNameToCertificate()
 	if srv.httpsServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -99,20 +174,21 @@ func (srv *Server) ListenAndServeHTTPS() error {
 		cancel()
 		srv.httpsServer = nil
 		time.Sleep(100 * time.Millisecond)
-		log.Print("stopped HTTPS server")
+		srv.logger.Info("stopped HTTPS server", "addr", srv.httpsAddr)
 	}
-	ln, err := net.Listen("tcp", srv.httpsAddr)
+	ln, err := srv.listen(srv.httpsAddr)
 	if err != nil {
 		return err
 	}
 	srv.httpsListener = ln
-	log.Print("created HTTPS listener")
+	srv.logger.Debug("created HTTPS listener", "addr", srv.httpsAddr)
 	tlsListener := tls.NewListener(srv.httpsListener, config)
 	srv.httpsServer = &http.Server{
-		Addr:    srv.httpAddr,
-		Handler: srv.handler,
+		Addr:     srv.httpAddr,
+		Handler:  srv.handler,
+		ErrorLog: metrics.NewErrorLog(srv.logger),
 	}
 	go srv.httpsServer.Serve(tlsListener)
-	log.Printf("started HTTPS server with %v certs", len(config.Certificates))
+	srv.logger.Info("started HTTPS server", "addr", srv.httpsAddr, "certs", len(config.Certificates))
 	return nil
 }
@@ -0,0 +1,17 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Middleware wraps a middleware of the chain in its own span
+func Middleware(id string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, span := Start(req.Context(), "middleware "+id)
+		span.SetAttributes(attribute.String("eve.middleware", id))
+		defer span.End()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
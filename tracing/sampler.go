@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type contextKey int
+
+const sampleRateKey contextKey = 0
+
+// WithSampleRate returns a context which makes the sampler use rate for new root spans
+func WithSampleRate(ctx context.Context, rate float64) context.Context {
+	return context.WithValue(ctx, sampleRateKey, rate)
+}
+
+// ruleSampler samples root spans with the rate of the matched loadbalancer rule
+type ruleSampler struct {
+	fallback float64
+}
+
+func (s ruleSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	rate := s.fallback
+	if r, ok := p.ParentContext.Value(sampleRateKey).(float64); ok {
+		rate = r
+	}
+	return sdktrace.TraceIDRatioBased(rate).ShouldSample(p)
+}
+
+func (s ruleSampler) Description() string {
+	return fmt.Sprintf("RuleSampler{fallback:%g}", s.fallback)
+}
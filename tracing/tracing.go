@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures the span exporter
+type Config struct {
+	// Exporter is one of "otlp", "file" or "" (tracing disabled)
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector address
+	Endpoint string
+	// Insecure disables TLS for the OTLP exporter
+	Insecure bool
+	// File is the output path of the file exporter
+	File string
+	// SampleRate is the sample rate of requests whose rule doesn't specify one
+	SampleRate float64
+}
+
+var tracer = otel.Tracer("github.com/trusch/eve")
+
+// Setup installs the global tracer provider and the W3C traceparent/baggage propagator.
+// The returned function flushes and stops the exporter.
+func Setup(cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch cfg.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "file":
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, errors.New("unknown tracing exporter: " + cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(ruleSampler{fallback: cfg.SampleRate})),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "eve"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Extract returns a context carrying the trace context and baggage of the incoming request
+func Extract(req *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// Inject writes the trace context and baggage of ctx into the headers of an outgoing request
+func Inject(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// Start starts a new span
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, opts...)
+}